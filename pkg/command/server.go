@@ -16,6 +16,7 @@ import (
 	zipkinhttp "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/owncloud/ocis-graph/pkg/config"
 	"github.com/owncloud/ocis-graph/pkg/flagset"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
 	"github.com/owncloud/ocis-graph/pkg/metrics"
 	"github.com/owncloud/ocis-graph/pkg/server/debug"
 	"github.com/owncloud/ocis-graph/pkg/server/http"
@@ -127,6 +128,7 @@ func Server(cfg *config.Config) *cli.Command {
 				gr          = run.Group{}
 				ctx, cancel = context.WithCancel(context.Background())
 				metrics     = metrics.New()
+				runner      = jobs.NewRunner(jobs.Logger(logger))
 			)
 
 			defer cancel()
@@ -137,6 +139,7 @@ func Server(cfg *config.Config) *cli.Command {
 					http.Context(ctx),
 					http.Config(cfg),
 					http.Metrics(metrics),
+					http.Jobs(runner),
 					http.Flags(flagset.RootWithConfig(cfg)),
 					http.Flags(flagset.ServerWithConfig(cfg)),
 				)
@@ -161,6 +164,18 @@ func Server(cfg *config.Config) *cli.Command {
 				})
 			}
 
+			{
+				gr.Add(func() error {
+					return runner.Run()
+				}, func(_ error) {
+					logger.Info().
+						Str("runner", "jobs").
+						Msg("Shutting down job runner")
+
+					runner.Stop()
+				})
+			}
+
 			{
 				server, err := debug.Server(
 					debug.Logger(logger),
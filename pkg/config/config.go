@@ -54,16 +54,56 @@ type Reva struct {
 	Address string
 }
 
+// Store defines the available store configuration.
+type Store struct {
+	Type string
+	Path string
+}
+
+// Sharing defines the available sharing configuration.
+type Sharing struct {
+	PublicURL string
+}
+
+// SpecialFolders defines the paths of the special folders inside the home.
+type SpecialFolders struct {
+	Documents string
+	Photos    string
+	AppRoot   string
+}
+
+// Recent defines the available configuration of recent files.
+type Recent struct {
+	MtimePropagation bool
+}
+
+// Drives defines the available configuration of drives.
+type Drives struct {
+	Projects []string
+}
+
+// Import defines the available configuration of imports from source urls.
+type Import struct {
+	AllowedHosts []string
+	MaxSize      int64
+}
+
 // Config combines all available configuration parts.
 type Config struct {
-	File          string
-	Log           Log
-	Debug         Debug
-	HTTP          HTTP
-	Tracing       Tracing
-	Ldap          Ldap
-	OpenIDConnect OpenIDConnect
-	Reva          Reva
+	File           string
+	Log            Log
+	Debug          Debug
+	HTTP           HTTP
+	Tracing        Tracing
+	Ldap           Ldap
+	OpenIDConnect  OpenIDConnect
+	Reva           Reva
+	Store          Store
+	Sharing        Sharing
+	SpecialFolders SpecialFolders
+	Recent         Recent
+	Drives         Drives
+	Import         Import
 }
 
 // New initializes a new configuration with or without defaults.
@@ -1,6 +1,8 @@
 package flagset
 
 import (
+	"strings"
+
 	"github.com/micro/cli/v2"
 	"github.com/owncloud/ocis-graph/pkg/config"
 )
@@ -205,5 +207,96 @@ func ServerWithConfig(cfg *config.Config) []cli.Flag {
 			EnvVars:     []string{"REVA_GATEWAY_ADDR"},
 			Destination: &cfg.Reva.Address,
 		},
+		&cli.StringFlag{
+			Name:        "store-type",
+			Value:       "memory",
+			Usage:       "Store for upload sessions and other state, either memory or file, thumbnails are only cached in the file store",
+			EnvVars:     []string{"GRAPH_STORE_TYPE"},
+			Destination: &cfg.Store.Type,
+		},
+		&cli.StringFlag{
+			Name:        "store-path",
+			Value:       "/var/tmp/ocis-graph/store",
+			Usage:       "Directory of the file store",
+			EnvVars:     []string{"GRAPH_STORE_PATH"},
+			Destination: &cfg.Store.Path,
+		},
+		&cli.StringFlag{
+			Name:        "public-url",
+			Value:       "https://localhost:9200",
+			Usage:       "Public base URL of the web UI, used for sharing links",
+			EnvVars:     []string{"GRAPH_PUBLIC_URL"},
+			Destination: &cfg.Sharing.PublicURL,
+		},
+		&cli.StringFlag{
+			Name:        "special-folder-documents",
+			Value:       "Documents",
+			Usage:       "Path of the documents special folder inside the home",
+			EnvVars:     []string{"GRAPH_SPECIAL_FOLDER_DOCUMENTS"},
+			Destination: &cfg.SpecialFolders.Documents,
+		},
+		&cli.StringFlag{
+			Name:        "special-folder-photos",
+			Value:       "Photos",
+			Usage:       "Path of the photos special folder inside the home",
+			EnvVars:     []string{"GRAPH_SPECIAL_FOLDER_PHOTOS"},
+			Destination: &cfg.SpecialFolders.Photos,
+		},
+		&cli.StringFlag{
+			Name:        "special-folder-approot",
+			Value:       "Apps/Graph",
+			Usage:       "Path of the approot special folder inside the home",
+			EnvVars:     []string{"GRAPH_SPECIAL_FOLDER_APPROOT"},
+			Destination: &cfg.SpecialFolders.AppRoot,
+		},
+		&cli.BoolFlag{
+			Name:        "recent-mtime-propagation",
+			Usage:       "Skip folders older than the recent files found so far, only for storages propagating modifications to the mtime of parent folders",
+			EnvVars:     []string{"GRAPH_RECENT_MTIME_PROPAGATION"},
+			Destination: &cfg.Recent.MtimePropagation,
+		},
+		&cli.GenericFlag{
+			Name:    "drives-projects",
+			Usage:   "Comma separated paths in the gateway namespace listed as project drives",
+			EnvVars: []string{"GRAPH_DRIVES_PROJECTS"},
+			Value:   &stringSlice{dst: &cfg.Drives.Projects},
+		},
+		&cli.GenericFlag{
+			Name:    "import-allowed-hosts",
+			Usage:   "Comma separated hosts files can be imported from by source url, all public hosts if empty",
+			EnvVars: []string{"GRAPH_IMPORT_ALLOWED_HOSTS"},
+			Value:   &stringSlice{dst: &cfg.Import.AllowedHosts},
+		},
+		&cli.Int64Flag{
+			Name:        "import-max-size",
+			Value:       1 << 30,
+			Usage:       "Maximum size in bytes of files imported by source url, 0 for no limit",
+			EnvVars:     []string{"GRAPH_IMPORT_MAX_SIZE"},
+			Destination: &cfg.Import.MaxSize,
+		},
+	}
+}
+
+// stringSlice binds a comma separated flag to a string slice of the
+// config, the StringSliceFlag of micro/cli has no destination.
+type stringSlice struct {
+	dst *[]string
+}
+
+// Set implements cli.Generic. Every use of the flag adds to the slice.
+func (s *stringSlice) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s.dst = append(*s.dst, v)
+		}
+	}
+	return nil
+}
+
+// String implements cli.Generic.
+func (s *stringSlice) String() string {
+	if s == nil || s.dst == nil {
+		return ""
 	}
+	return strings.Join(*s.dst, ",")
 }
@@ -0,0 +1,268 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/owncloud/ocis-pkg/v2/log"
+)
+
+// Status defines the state of a job, see https://docs.microsoft.com/en-us/graph/long-running-actions-overview
+type Status string
+
+const (
+	// StatusNotStarted defines a job that is queued.
+	StatusNotStarted Status = "notStarted"
+	// StatusInProgress defines a running job.
+	StatusInProgress Status = "inProgress"
+	// StatusCompleted defines a job that finished successfully.
+	StatusCompleted Status = "completed"
+	// StatusFailed defines a job that finished with an error.
+	StatusFailed Status = "failed"
+)
+
+// ErrStopped is returned when submitting jobs to a runner that is not
+// running. Jobs still queued when the runner stops fail with it.
+var ErrStopped = errors.New("job runner stopped")
+
+// ErrBusy is returned when submitting jobs to a runner with a full queue.
+var ErrBusy = errors.New("job queue full")
+
+// Job is a snapshot of the state of an asynchronous operation. It is
+// rendered as monitor response.
+type Job struct {
+	ID                 string    `json:"-"`
+	Operation          string    `json:"operation,omitempty"`
+	Status             Status    `json:"status"`
+	PercentageComplete float64   `json:"percentageComplete"`
+	ResourceID         string    `json:"resourceId,omitempty"`
+	Err                error     `json:"-"`
+	Finished           time.Time `json:"-"`
+}
+
+// Progress reports the completion of a job in percent.
+type Progress func(percentage float64)
+
+// Func is the work of a job. It returns the id of the resulting resource.
+type Func func(ctx context.Context, progress Progress) (string, error)
+
+type task struct {
+	id string
+	fn Func
+}
+
+// Runner executes jobs in the background. It is meant to be added to the
+// run group of the server, so jobs are cancelled on shutdown.
+type Runner struct {
+	logger  log.Logger
+	workers int
+	ttl     time.Duration
+
+	queue chan task
+	stop  chan struct{}
+	once  sync.Once
+
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	stopped bool
+}
+
+// NewRunner initializes a new job runner.
+func NewRunner(opts ...Option) *Runner {
+	options := newOptions(opts...)
+
+	return &Runner{
+		logger:  options.Logger,
+		workers: options.Workers,
+		ttl:     options.TTL,
+		queue:   make(chan task, 100),
+		stop:    make(chan struct{}),
+		jobs:    map[string]*Job{},
+	}
+}
+
+// Run starts the workers and blocks until Stop is called.
+func (r *Runner) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < r.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			cancel()
+			wg.Wait()
+			r.failQueued()
+			return nil
+		case <-ticker.C:
+			r.expire()
+		}
+	}
+}
+
+// Stop cancels all running jobs and stops the workers.
+func (r *Runner) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+// Submit queues a new job and returns its id. It does not wait for room in
+// the queue, ErrBusy is returned if it is full.
+func (r *Runner) Submit(operation string, fn Func) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	select {
+	case <-r.stop:
+		return "", ErrStopped
+	default:
+	}
+
+	// queueing under the lock keeps failQueued from missing the job
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return "", ErrStopped
+	}
+
+	select {
+	case r.queue <- task{id: id, fn: fn}:
+		r.jobs[id] = &Job{
+			ID:        id,
+			Operation: operation,
+			Status:    StatusNotStarted,
+		}
+		return id, nil
+	default:
+		return "", ErrBusy
+	}
+}
+
+// Get returns a snapshot of the job with the given id.
+func (r *Runner) Get(id string) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (r *Runner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-r.queue:
+			if ctx.Err() != nil {
+				// the select does not prefer the stop, fail the job like failQueued
+				r.update(t.id, func(job *Job) {
+					job.Status = StatusFailed
+					job.Err = ErrStopped
+					job.Finished = time.Now()
+				})
+				return
+			}
+			r.update(t.id, func(job *Job) {
+				job.Status = StatusInProgress
+			})
+
+			resourceID, err := r.call(ctx, t)
+
+			r.update(t.id, func(job *Job) {
+				job.Finished = time.Now()
+				if err != nil {
+					r.logger.Error().Err(err).Str("job", t.id).Str("operation", job.Operation).Msg("job failed")
+					job.Status = StatusFailed
+					job.Err = err
+					return
+				}
+				job.Status = StatusCompleted
+				job.PercentageComplete = 100
+				job.ResourceID = resourceID
+			})
+		}
+	}
+}
+
+// call runs the func of a job. A panic fails the job instead of taking
+// down the service.
+func (r *Runner) call(ctx context.Context, t task) (resourceID string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.logger.Error().Str("job", t.id).Str("stack", string(debug.Stack())).Msgf("job panicked: %v", p)
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+
+	return t.fn(ctx, func(percentage float64) {
+		r.update(t.id, func(job *Job) {
+			job.PercentageComplete = percentage
+		})
+	})
+}
+
+// failQueued fails the jobs that were not started before the runner
+// stopped and rejects new ones.
+func (r *Runner) failQueued() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	for {
+		select {
+		case t := <-r.queue:
+			if job, ok := r.jobs[t.id]; ok {
+				job.Status = StatusFailed
+				job.Err = ErrStopped
+				job.Finished = time.Now()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (r *Runner) update(id string, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// expire removes finished jobs older than the ttl.
+func (r *Runner) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, job := range r.jobs {
+		if !job.Finished.IsZero() && time.Since(job.Finished) > r.ttl {
+			delete(r.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/owncloud/ocis-pkg/v2/log"
+)
+
+// startRunner runs a new runner until the returned stop func is called.
+func startRunner(opts ...Option) (*Runner, func()) {
+	r := NewRunner(append([]Option{Logger(log.NewLogger(log.Level("fatal")))}, opts...)...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = r.Run()
+	}()
+	return r, func() {
+		r.Stop()
+		<-done
+	}
+}
+
+func waitFinished(t *testing.T, r *Runner, id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := r.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.Status == StatusCompleted || job.Status == StatusFailed {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestRunner(t *testing.T) {
+	errJob := errors.New("job error")
+
+	tests := []struct {
+		name       string
+		fn         Func
+		status     Status
+		percentage float64
+		resourceID string
+		err        error
+	}{
+		{
+			name: "completed",
+			fn: func(ctx context.Context, progress Progress) (string, error) {
+				progress(50)
+				return "resource", nil
+			},
+			status:     StatusCompleted,
+			percentage: 100,
+			resourceID: "resource",
+		},
+		{
+			name: "failed",
+			fn: func(ctx context.Context, progress Progress) (string, error) {
+				progress(50)
+				return "", errJob
+			},
+			status:     StatusFailed,
+			percentage: 50,
+			err:        errJob,
+		},
+	}
+
+	r, stop := startRunner()
+	defer stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := r.Submit("test", tt.fn)
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+
+			job := waitFinished(t, r, id)
+			if job.Operation != "test" {
+				t.Errorf("Operation = %q, want %q", job.Operation, "test")
+			}
+			if job.Status != tt.status {
+				t.Errorf("Status = %q, want %q", job.Status, tt.status)
+			}
+			if job.PercentageComplete != tt.percentage {
+				t.Errorf("PercentageComplete = %v, want %v", job.PercentageComplete, tt.percentage)
+			}
+			if job.ResourceID != tt.resourceID {
+				t.Errorf("ResourceID = %q, want %q", job.ResourceID, tt.resourceID)
+			}
+			if job.Err != tt.err {
+				t.Errorf("Err = %v, want %v", job.Err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRunnerGetUnknown(t *testing.T) {
+	r := NewRunner()
+	if _, ok := r.Get("unknown"); ok {
+		t.Error("Get() of an unknown job succeeded")
+	}
+}
+
+func TestRunnerSubmitBusy(t *testing.T) {
+	// without running workers the queue is never drained
+	r := NewRunner()
+	for i := 0; i < cap(r.queue); i++ {
+		if _, err := r.Submit("test", nil); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	if _, err := r.Submit("test", nil); err != ErrBusy {
+		t.Fatalf("Submit() error = %v, want %v", err, ErrBusy)
+	}
+	if len(r.jobs) != cap(r.queue) {
+		t.Errorf("rejected job was kept, %d jobs", len(r.jobs))
+	}
+}
+
+func TestRunnerSubmitStopped(t *testing.T) {
+	r := NewRunner()
+	r.Stop()
+	if _, err := r.Submit("test", nil); err != ErrStopped {
+		t.Fatalf("Submit() error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestRunnerPanic(t *testing.T) {
+	r, stop := startRunner()
+	defer stop()
+
+	id, err := r.Submit("test", func(ctx context.Context, progress Progress) (string, error) {
+		panic("boom")
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job := waitFinished(t, r, id); job.Status != StatusFailed || job.Err == nil {
+		t.Errorf("job = %+v, want failed", job)
+	}
+
+	// the worker survives the panic
+	id, err = r.Submit("test", func(ctx context.Context, progress Progress) (string, error) {
+		return "resource", nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if job := waitFinished(t, r, id); job.Status != StatusCompleted {
+		t.Errorf("job = %+v, want completed", job)
+	}
+}
+
+func TestRunnerStopFailsQueued(t *testing.T) {
+	r, stop := startRunner(Workers(1))
+
+	started := make(chan struct{})
+	running, err := r.Submit("test", func(ctx context.Context, progress Progress) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	<-started
+	queued, err := r.Submit("test", func(ctx context.Context, progress Progress) (string, error) {
+		return "resource", nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	stop()
+
+	for _, id := range []string{running, queued} {
+		if job, _ := r.Get(id); job.Status != StatusFailed {
+			t.Errorf("job %s status = %s, want %s", id, job.Status, StatusFailed)
+		}
+	}
+	if _, err := r.Submit("test", nil); err != ErrStopped {
+		t.Errorf("Submit() error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestRunnerExpire(t *testing.T) {
+	r := NewRunner(TTL(time.Minute))
+	r.jobs["old"] = &Job{ID: "old", Status: StatusCompleted, Finished: time.Now().Add(-time.Hour)}
+	r.jobs["new"] = &Job{ID: "new", Status: StatusCompleted, Finished: time.Now()}
+	r.jobs["running"] = &Job{ID: "running", Status: StatusInProgress}
+
+	r.expire()
+
+	for id, want := range map[string]bool{"old": false, "new": true, "running": true} {
+		if _, ok := r.Get(id); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", id, ok, want)
+		}
+	}
+}
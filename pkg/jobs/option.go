@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/owncloud/ocis-pkg/v2/log"
+)
+
+// Option defines a single option function.
+type Option func(o *Options)
+
+// Options defines the available options for this package.
+type Options struct {
+	Logger  log.Logger
+	Workers int
+	TTL     time.Duration
+}
+
+// newOptions initializes the available default options.
+func newOptions(opts ...Option) Options {
+	opt := Options{
+		Workers: 4,
+		TTL:     time.Hour,
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// Logger provides a function to set the logger option.
+func Logger(val log.Logger) Option {
+	return func(o *Options) {
+		o.Logger = val
+	}
+}
+
+// Workers provides a function to set the number of jobs running in parallel.
+func Workers(val int) Option {
+	return func(o *Options) {
+		o.Workers = val
+	}
+}
+
+// TTL provides a function to set how long finished jobs can be monitored.
+func TTL(val time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = val
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/micro/cli/v2"
 	"github.com/owncloud/ocis-graph/pkg/config"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
 	"github.com/owncloud/ocis-graph/pkg/metrics"
 	"github.com/owncloud/ocis-pkg/v2/log"
 )
@@ -18,6 +19,7 @@ type Options struct {
 	Context   context.Context
 	Config    *config.Config
 	Metrics   *metrics.Metrics
+	Jobs      *jobs.Runner
 	Flags     []cli.Flag
 	Namespace string
 }
@@ -61,6 +63,13 @@ func Metrics(val *metrics.Metrics) Option {
 	}
 }
 
+// Jobs provides a function to set the jobs option.
+func Jobs(val *jobs.Runner) Option {
+	return func(o *Options) {
+		o.Jobs = val
+	}
+}
+
 // Flags provides a function to set the flags option.
 func Flags(val []cli.Flag) Option {
 	return func(o *Options) {
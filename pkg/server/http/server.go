@@ -2,6 +2,7 @@ package http
 
 import (
 	svc "github.com/owncloud/ocis-graph/pkg/service/v0"
+	"github.com/owncloud/ocis-graph/pkg/store"
 	"github.com/owncloud/ocis-graph/pkg/version"
 	"github.com/owncloud/ocis-pkg/v2/middleware"
 	"github.com/owncloud/ocis-pkg/v2/oidc"
@@ -22,9 +23,16 @@ func Server(opts ...Option) (http.Service, error) {
 		http.Flags(options.Flags...),
 	)
 
+	st, err := store.New(options.Config.Store)
+	if err != nil {
+		return http.Service{}, err
+	}
+
 	handle := svc.NewService(
 		svc.Logger(options.Logger),
 		svc.Config(options.Config),
+		svc.Store(st),
+		svc.Jobs(options.Jobs),
 		svc.Middleware(
 			middleware.RealIP,
 			middleware.RequestID,
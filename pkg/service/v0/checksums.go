@@ -0,0 +1,103 @@
+package svc
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// checksumsMetadataKey asks the storage providers to return all checksums
+// they know of a file. They are returned in the checksums opaque entry,
+// formatted like "SHA1:<hex> MD5:<hex> ADLER32:<hex>".
+const checksumsMetadataKey = "http://owncloud.org/ns/checksums"
+
+// driveItemMetadataKeys are requested when stating or listing resources
+// that are converted into drive items.
+var driveItemMetadataKeys = []string{checksumsMetadataKey, lockMetadataKey}
+
+// resourceChecksums collects the checksums of a resource by their upper
+// case type, e.g. SHA1.
+func resourceChecksums(info *storageprovider.ResourceInfo) map[string]string {
+	sums := map[string]string{}
+	parse := func(s string) {
+		for _, f := range strings.Fields(s) {
+			parts := strings.SplitN(f, ":", 2)
+			if len(parts) == 2 && parts[1] != "" {
+				sums[strings.ToUpper(parts[0])] = parts[1]
+			}
+		}
+	}
+	if info.Opaque != nil {
+		if e, ok := info.Opaque.Map["checksums"]; ok {
+			parse(string(e.Value))
+		}
+	}
+	if info.ArbitraryMetadata != nil {
+		parse(info.ArbitraryMetadata.Metadata[checksumsMetadataKey])
+	}
+	if info.Checksum != nil && info.Checksum.Sum != "" {
+		switch info.Checksum.Type {
+		case storageprovider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_SHA1:
+			sums["SHA1"] = info.Checksum.Sum
+		case storageprovider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_MD5:
+			sums["MD5"] = info.Checksum.Sum
+		case storageprovider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_ADLER32:
+			sums["ADLER32"] = info.Checksum.Sum
+		}
+	}
+	return sums
+}
+
+// cs3ChecksumsToHashes converts the checksums of a resource into the hashes
+// facet. CS3 storages only compute SHA1, MD5 and ADLER32, CRC32 and
+// QuickXorHash are only set if a storage provides them. Returns nil if no
+// hash is known.
+func cs3ChecksumsToHashes(info *storageprovider.ResourceInfo) *msgraph.Hashes {
+	sums := resourceChecksums(info)
+	hashes := &msgraph.Hashes{}
+	found := false
+	if s, ok := sums["SHA1"]; ok {
+		s = strings.ToUpper(s)
+		hashes.Sha1Hash = &s
+		found = true
+	}
+	if s, ok := sums["CRC32"]; ok {
+		s = strings.ToUpper(s)
+		hashes.Crc32Hash = &s
+		found = true
+	}
+	if s, ok := sums["QUICKXOR"]; ok {
+		hashes.QuickXorHash = &s
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return hashes
+}
+
+// digestHeader formats the checksums of a resource as an RFC 3230 Digest
+// header value. Returns an empty string if no checksum is known.
+func digestHeader(info *storageprovider.ResourceInfo) string {
+	sums := resourceChecksums(info)
+	digests := []string{}
+	for _, alg := range []struct {
+		checksum, digest string
+	}{
+		{"SHA1", "SHA"},
+		{"MD5", "MD5"},
+	} {
+		b, err := hex.DecodeString(sums[alg.checksum])
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		digests = append(digests, alg.digest+"="+base64.StdEncoding.EncodeToString(b))
+	}
+	if s, ok := sums["ADLER32"]; ok {
+		digests = append(digests, "ADLER32="+strings.ToLower(s))
+	}
+	return strings.Join(digests, ",")
+}
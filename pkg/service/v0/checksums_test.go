@@ -0,0 +1,108 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	types "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+)
+
+const (
+	emptySHA1 = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	emptyMD5  = "d41d8cd98f00b204e9800998ecf8427e"
+)
+
+func TestResourceChecksums(t *testing.T) {
+	tests := []struct {
+		name string
+		info *storageprovider.ResourceInfo
+		want map[string]string
+	}{
+		{
+			name: "none",
+			info: &storageprovider.ResourceInfo{},
+			want: map[string]string{},
+		},
+		{
+			name: "opaque",
+			info: &storageprovider.ResourceInfo{
+				Opaque: &types.Opaque{Map: map[string]*types.OpaqueEntry{
+					"checksums": {Decoder: "plain", Value: []byte("SHA1:" + emptySHA1 + " md5:" + emptyMD5 + " ADLER32:")},
+				}},
+			},
+			want: map[string]string{"SHA1": emptySHA1, "MD5": emptyMD5},
+		},
+		{
+			name: "arbitrary metadata",
+			info: &storageprovider.ResourceInfo{
+				ArbitraryMetadata: &storageprovider.ArbitraryMetadata{Metadata: map[string]string{
+					checksumsMetadataKey: "ADLER32:00000001 invalid",
+				}},
+			},
+			want: map[string]string{"ADLER32": "00000001"},
+		},
+		{
+			name: "checksum field wins",
+			info: &storageprovider.ResourceInfo{
+				Opaque: &types.Opaque{Map: map[string]*types.OpaqueEntry{
+					"checksums": {Value: []byte("MD5:0000")},
+				}},
+				Checksum: &storageprovider.ResourceChecksum{
+					Type: storageprovider.ResourceChecksumType_RESOURCE_CHECKSUM_TYPE_MD5,
+					Sum:  emptyMD5,
+				},
+			},
+			want: map[string]string{"MD5": emptyMD5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resourceChecksums(tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resourceChecksums() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		checksum string
+		want     string
+	}{
+		{"none", "", ""},
+		{"sha1", "SHA1:" + emptySHA1, "SHA=2jmj7l5rSw0yVb/vlWAYkK/YBwk="},
+		{"all", "SHA1:" + emptySHA1 + " MD5:" + emptyMD5 + " ADLER32:0000ABCD", "SHA=2jmj7l5rSw0yVb/vlWAYkK/YBwk=,MD5=1B2M2Y8AsgTpgAmY7PhCfg==,ADLER32=0000abcd"},
+		{"invalid hex", "SHA1:xyz MD5:" + emptyMD5, "MD5=1B2M2Y8AsgTpgAmY7PhCfg=="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &storageprovider.ResourceInfo{
+				ArbitraryMetadata: &storageprovider.ArbitraryMetadata{Metadata: map[string]string{
+					checksumsMetadataKey: tt.checksum,
+				}},
+			}
+			if got := digestHeader(info); got != tt.want {
+				t.Errorf("digestHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCs3ChecksumsToHashes(t *testing.T) {
+	info := &storageprovider.ResourceInfo{
+		ArbitraryMetadata: &storageprovider.ArbitraryMetadata{Metadata: map[string]string{
+			checksumsMetadataKey: "SHA1:" + emptySHA1 + " MD5:" + emptyMD5,
+		}},
+	}
+	hashes := cs3ChecksumsToHashes(info)
+	if hashes == nil || hashes.Sha1Hash == nil || *hashes.Sha1Hash != "DA39A3EE5E6B4B0D3255BFEF95601890AFD80709" {
+		t.Errorf("cs3ChecksumsToHashes() = %+v, want the upper case SHA1", hashes)
+	}
+	if hashes := cs3ChecksumsToHashes(&storageprovider.ResourceInfo{}); hashes != nil {
+		t.Errorf("cs3ChecksumsToHashes() without checksums = %+v, want nil", hashes)
+	}
+}
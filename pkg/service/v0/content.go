@@ -0,0 +1,239 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+)
+
+// tokenTransportHeader holds the transfer token when talking to the reva data gateway.
+const tokenTransportHeader = "X-Reva-Transfer"
+
+var errInvalidRange = errors.New("invalid range")
+
+// byteRange defines a single byte range of a file, see https://tools.ietf.org/html/rfc7233#section-2.1
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a Range header for a file of the given size. Only a
+// single range is supported. It returns nil if the header is empty, uses
+// another unit than bytes or asks for multiple ranges, such headers are
+// ignored, see RFC 7233 section 3.1.
+func parseRange(s string, size int64) (*byteRange, error) {
+	const b = "bytes="
+	if len(s) < len(b) || !strings.EqualFold(s[:len(b)], b) {
+		return nil, nil
+	}
+	spec := strings.TrimSpace(s[len(b):])
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return nil, errInvalidRange
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	br := &byteRange{}
+	switch {
+	case first == "":
+		// suffix range, the last n bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		br.start, br.length = size-n, n
+	default:
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 || start >= size {
+			return nil, errInvalidRange
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, errInvalidRange
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		br.start, br.length = start, end-start+1
+	}
+	if br.length <= 0 {
+		return nil, errInvalidRange
+	}
+	return br, nil
+}
+
+// newDataRequest creates a request against the reva data gateway.
+func newDataRequest(ctx context.Context, method, url, transferToken string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(tokenTransportHeader, transferToken)
+	return req, nil
+}
+
+// newDataClient returns the client for the transfers with the data gateway.
+// Transfers of large files take long, so only connecting and waiting for
+// the response headers time out. Transfers end with the context of the
+// request or job.
+func newDataClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   16,
+		},
+	}
+}
+
+// downloadFile requests the content of the file at ref from the data
+// gateway. The caller has to close the body of the response.
+func downloadFile(ctx context.Context, httpClient *http.Client, client gateway.GatewayAPIClient, ref *storageprovider.Reference, rangeHeader string) (*http.Response, error) {
+	dRes, err := client.InitiateFileDownload(ctx, &storageprovider.InitiateFileDownloadRequest{Ref: ref})
+	if err != nil {
+		return nil, err
+	}
+	if dRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, &statusError{status: dRes.Status}
+	}
+
+	req, err := newDataRequest(ctx, http.MethodGet, dRes.DownloadEndpoint, dRes.Token, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	return httpClient.Do(req)
+}
+
+// GetDriveItemContent streams the content of the file resolved by DriveItemCtx.
+// Partial downloads are supported with a single range in the Range header.
+func (g Graph) GetDriveItemContent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	info := statRes.Info
+	if info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	size := int64(info.Size)
+	br, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		g.logger.Debug().Str("range", r.Header.Get("Range")).Msg("invalid range requested")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		errorcode.InvalidRange.Render(w, r, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	rangeHeader := ""
+	if br != nil {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", br.start, br.start+br.length-1)
+	}
+	res, err := downloadFile(ctx, g.dataClient, client, ref, rangeHeader)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error downloading file %s", ref)
+		renderError(w, r, err)
+		return
+	}
+	defer res.Body.Close()
+
+	var body io.Reader = res.Body
+	switch res.StatusCode {
+	case http.StatusOK:
+		if br != nil {
+			// the data gateway ignored the range, cut it out ourselves
+			if _, err := io.CopyN(ioutil.Discard, res.Body, br.start); err != nil {
+				g.logger.Error().Err(err).Msg("error skipping data gateway response")
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			body = io.LimitReader(res.Body, br.length)
+		}
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return
+	default:
+		g.logger.Error().Int("status", res.StatusCode).Msg("unexpected data gateway response")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", info.MimeType)
+	w.Header().Set("ETag", info.Etag)
+	w.Header().Set("Last-Modified", time.Unix(int64(info.Mtime.Seconds), int64(info.Mtime.Nanos)).UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if digest := digestHeader(info); digest != "" {
+		w.Header().Set("Digest", digest)
+	}
+	status := http.StatusOK
+	length := size
+	if br != nil {
+		status = http.StatusPartialContent
+		length = br.length
+		w.Header().Set("Content-Range", br.contentRange(size))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, body); err != nil {
+		g.logger.Error().Err(err).Msg("error streaming file content")
+	}
+}
@@ -0,0 +1,53 @@
+package svc
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		size    int64
+		want    *byteRange
+		wantErr bool
+	}{
+		{header: "", size: 100},
+		{header: "bytes=0-9", size: 100, want: &byteRange{start: 0, length: 10}},
+		{header: "bytes=90-", size: 100, want: &byteRange{start: 90, length: 10}},
+		{header: "bytes=-10", size: 100, want: &byteRange{start: 90, length: 10}},
+		{header: "bytes=-200", size: 100, want: &byteRange{start: 0, length: 100}},
+		{header: "bytes=50-200", size: 100, want: &byteRange{start: 50, length: 50}},
+		{header: "bytes= 5 - 9 ", size: 100, want: &byteRange{start: 5, length: 5}},
+		{header: "Bytes=0-0", size: 100, want: &byteRange{start: 0, length: 1}},
+		{header: "items=0-9", size: 100},
+		{header: "bytes 0-9", size: 100},
+		{header: "bytes=100-", size: 100, wantErr: true},
+		{header: "bytes=9-5", size: 100, wantErr: true},
+		{header: "bytes=-0", size: 100, wantErr: true},
+		{header: "bytes=0-9,20-29", size: 100},
+		{header: "bytes=a-b", size: 100, wantErr: true},
+		{header: "bytes=5", size: 100, wantErr: true},
+		{header: "bytes=-5", size: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange(%q, %d) error = %v, want error %v", tt.header, tt.size, err, tt.wantErr)
+			}
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("parseRange(%q, %d) = %v, want %v", tt.header, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	br := byteRange{start: 10, length: 5}
+	if got, want := br.contentRange(100), "bytes 10-14/100"; got != want {
+		t.Errorf("contentRange() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,276 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/token"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// copier copies a tree of resources through the CS3 gateway and reports
+// the progress by the number of bytes copied.
+type copier struct {
+	httpClient *http.Client
+	client     gateway.GatewayAPIClient
+	total      int64
+	done       int64
+	progress   jobs.Progress
+}
+
+func (c *copier) add(n int64) {
+	done := atomic.AddInt64(&c.done, n)
+	if c.total > 0 {
+		c.progress(float64(done) * 100 / float64(c.total))
+	}
+}
+
+// copy copies the resource src to the path dst.
+func (c *copier) copy(ctx context.Context, src *storageprovider.ResourceInfo, dst string) error {
+	dstRef := &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: dst},
+	}
+	if src.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		return c.copyFile(ctx, src, dstRef)
+	}
+
+	createRes, err := c.client.CreateContainer(ctx, &storageprovider.CreateContainerRequest{Ref: dstRef})
+	if err != nil {
+		return err
+	}
+	if createRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: createRes.Status}
+	}
+
+	listRes, err := c.client.ListContainer(ctx, &storageprovider.ListContainerRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: src.Path},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if listRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: listRes.Status}
+	}
+	for _, child := range listRes.Infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.copy(ctx, child, path.Join(dst, path.Base(child.Path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *copier) copyFile(ctx context.Context, src *storageprovider.ResourceInfo, dst *storageprovider.Reference) error {
+	res, err := downloadFile(ctx, c.httpClient, c.client, &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: src.Path},
+	}, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected data gateway response %d downloading %s", res.StatusCode, src.Path)
+	}
+
+	if err := checkRefLock(ctx, c.client, dst); err != nil {
+		return err
+	}
+	return uploadFile(ctx, c.httpClient, c.client, dst, int64(src.Size), &progressReader{r: res.Body, c: c})
+}
+
+// progressReader reports the bytes read to the copier.
+type progressReader struct {
+	r io.Reader
+	c *copier
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.c.add(int64(n))
+	return n, err
+}
+
+// deletePartialCopy removes the destination of a failed copy. Errors are
+// only logged, the copy already failed.
+func (g Graph) deletePartialCopy(ctx context.Context, client gateway.GatewayAPIClient, dst string) {
+	res, err := client.Delete(ctx, &storageprovider.DeleteRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: dst},
+		},
+	})
+	switch {
+	case err != nil:
+		g.logger.Error().Err(err).Msgf("error sending delete grpc request %s", dst)
+	case res.Status.Code != cs3rpc.Code_CODE_OK && res.Status.Code != cs3rpc.Code_CODE_NOT_FOUND:
+		g.logger.Error().Str("code", res.Status.Code.String()).Msgf("error deleting partial copy %s", dst)
+	}
+}
+
+// CopyDriveItem copies the item resolved by DriveItemCtx in the background.
+// The response carries the url of a monitor that reports the progress,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-copy?view=graph-rest-1.0
+func (g Graph) CopyDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := struct {
+		Name            string                 `json:"name"`
+		ParentReference *msgraph.ItemReference `json:"parentReference"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode copy drive item request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(req.Name, "/") || req.Name == "." || req.Name == ".." {
+		g.logger.Debug().Str("name", req.Name).Msg("invalid drive item name")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	cb, err := parseConflictBehavior(r.URL.Query().Get("@microsoft.graph.conflictBehavior"), conflictBehaviorFail)
+	if err != nil || cb == conflictBehaviorReplace {
+		// replacing a tree that is still being copied is not supported
+		g.logger.Debug().Str("conflictBehavior", r.URL.Query().Get("@microsoft.graph.conflictBehavior")).Msg("invalid conflict behavior")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	src := statRes.Info
+	if src.Path == root.Path {
+		errorcode.NotAllowed.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	dir, name := path.Split(src.Path)
+	if req.Name != "" {
+		name = req.Name
+	}
+	if req.ParentReference != nil {
+		if dir, err = resolveParentPath(ctx, client, root, req.ParentReference); err != nil {
+			g.logger.Debug().Err(err).Msg("could not resolve parent reference")
+			renderError(w, r, err)
+			return
+		}
+	}
+	dst := path.Join(dir, name)
+	if dst == src.Path || strings.HasPrefix(dst, src.Path+"/") {
+		// a folder can not be copied into itself
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	dstRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: dst},
+		},
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", dst)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	switch dstRes.Status.Code {
+	case cs3rpc.Code_CODE_OK:
+		if cb == conflictBehaviorFail {
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		}
+		available, err := findAvailablePath(ctx, client, dst, src.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error finding available name for %s", dst)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		dst = available
+	case cs3rpc.Code_CODE_NOT_FOUND:
+	default:
+		g.logger.Debug().Str("code", dstRes.Status.Code.String()).Msgf("error calling grpc stat %s", dst)
+		renderStatus(w, r, dstRes.Status)
+		return
+	}
+
+	// the copy needs as much room as the source, the sizes of folders
+	// include their descendants
+	if err := checkQuota(ctx, client, root, 0, int64(src.Size)); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking quota for copy of %s", src.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	// the job outlives the request, so it needs its own copy of the reva token
+	revaToken, _ := token.ContextGetToken(ctx)
+	jobID, err := g.jobs.Submit("itemCopy", func(ctx context.Context, progress jobs.Progress) (string, error) {
+		ctx = withRevaToken(ctx, revaToken)
+		// the quota may have changed while the job was queued
+		if err := checkQuota(ctx, client, root, 0, int64(src.Size)); err != nil {
+			return "", err
+		}
+		c := &copier{
+			httpClient: g.dataClient,
+			client:     client,
+			total:      int64(src.Size),
+			progress:   progress,
+		}
+		if err := c.copy(ctx, src, dst); err != nil {
+			// the destination did not exist before, remove what was copied
+			// so far, also when the job was cancelled
+			g.deletePartialCopy(withRevaToken(context.Background(), revaToken), client, dst)
+			return "", err
+		}
+
+		res, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: dst},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			return "", &statusError{status: res.Status}
+		}
+		return wrapResourceID(res.Info.Id), nil
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error submitting copy of %s to %s", src.Path, dst)
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Location", g.absoluteURL(r, "/monitor/"+jobID))
+	w.WriteHeader(http.StatusAccepted)
+}
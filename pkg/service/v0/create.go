@@ -0,0 +1,200 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// driveItemRequest defines the drive item properties clients can send
+// when creating a new item.
+type driveItemRequest struct {
+	Name             string          `json:"name"`
+	Folder           *msgraph.Folder `json:"folder"`
+	ConflictBehavior string          `json:"@microsoft.graph.conflictBehavior"`
+	SourceURL        string          `json:"@microsoft.graph.sourceUrl"`
+}
+
+// CreateDriveItem creates a folder in the folder resolved by DriveItemCtx.
+// Files with a source url are imported in the background,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-post-children?view=graph-rest-1.0
+func (g Graph) CreateDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := &driveItemRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode create drive item request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" || strings.Contains(req.Name, "/") || req.Name == "." || req.Name == ".." {
+		g.logger.Debug().Str("name", req.Name).Msg("invalid drive item name")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	var source *url.URL
+	switch {
+	case req.SourceURL != "":
+		var ok bool
+		if source, ok = g.parseSourceURL(req.SourceURL); !ok || req.Folder != nil {
+			g.logger.Debug().Str("sourceUrl", req.SourceURL).Msg("invalid source url")
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+	case req.Folder == nil:
+		errorcode.NotSupported.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	cb, err := parseConflictBehavior(req.ConflictBehavior, conflictBehaviorFail)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("invalid conflict behavior")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	parentRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if parentRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", parentRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, parentRes.Status)
+		return
+	}
+	if parentRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	fn := path.Join(parentRes.Info.Path, req.Name)
+	target := &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: fn},
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   target,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", fn)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	var replaced uint64
+	switch statRes.Status.Code {
+	case cs3rpc.Code_CODE_OK:
+		switch cb {
+		case conflictBehaviorFail:
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		case conflictBehaviorRename:
+			available, err := findAvailablePath(ctx, client, fn, source != nil)
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error finding available name for %s", fn)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			fn = available
+			target = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: fn},
+			}
+		case conflictBehaviorReplace:
+			if source != nil {
+				// the import uploads a new version, the file is kept if it fails
+				if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+					errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+					return
+				}
+				if err := checkLock(ctx, statRes.Info); err != nil {
+					g.logger.Debug().Err(err).Msgf("error checking checkout of %s", fn)
+					renderError(w, r, err)
+					return
+				}
+				replaced = statRes.Info.Size
+				break
+			}
+			if err := checkTreeLock(ctx, client, statRes.Info); err != nil {
+				g.logger.Debug().Err(err).Msgf("error checking checkout of %s", fn)
+				renderError(w, r, err)
+				return
+			}
+			delRes, err := client.Delete(ctx, &storageprovider.DeleteRequest{Ref: target})
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error sending delete grpc request %s", fn)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			if delRes.Status.Code != cs3rpc.Code_CODE_OK {
+				g.logger.Debug().Str("code", delRes.Status.Code.String()).Msgf("error calling grpc delete %s", fn)
+				renderStatus(w, r, delRes.Status)
+				return
+			}
+		}
+	case cs3rpc.Code_CODE_NOT_FOUND:
+	default:
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", fn)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+
+	if source != nil {
+		g.importDriveItem(w, r, client, source, fn, replaced)
+		return
+	}
+
+	createRes, err := client.CreateContainer(ctx, &storageprovider.CreateContainerRequest{Ref: target})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending create container grpc request %s", fn)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if createRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", createRes.Status.Code.String()).Msgf("error calling grpc create container %s", fn)
+		renderStatus(w, r, createRes.Status)
+		return
+	}
+
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   target,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", fn)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", fn)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(res.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, item)
+}
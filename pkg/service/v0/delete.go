@@ -0,0 +1,317 @@
+package svc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"path"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// DeleteDriveItem moves the item resolved by DriveItemCtx to the recycle bin,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-delete?view=graph-rest-1.0
+func (g Graph) DeleteDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Path == root.Path {
+		errorcode.NotAllowed.Render(w, r, http.StatusForbidden)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, statRes.Info.Etag) {
+		errorcode.ResourceModified.Render(w, r, http.StatusPreconditionFailed)
+		return
+	}
+	if err := checkTreeLock(ctx, client, statRes.Info); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking checkout of %s", statRes.Info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	res, err := client.Delete(ctx, &storageprovider.DeleteRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending delete grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc delete %s", ref)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recycleItemToDriveItem converts a CS3 recycle item into a drive item
+// with a deleted facet. The id of the drive item is the encoded recycle key.
+func recycleItemToDriveItem(item *storageprovider.RecycleItem, root *storageprovider.ResourceInfo) *msgraph.DriveItem {
+	id := base64.URLEncoding.EncodeToString([]byte(item.Key))
+	name := path.Base(item.Path)
+	size := new(int)
+	*size = int(item.Size) // uint64 -> int :boom:
+	state := "deleted"
+	driveID := wrapResourceID(root.Id)
+	// the paths of recycle items are relative to the drive root
+	parentPath := parentReferencePath(driveID, "/", path.Join("/", item.Path))
+
+	driveItem := &msgraph.DriveItem{
+		BaseItem: msgraph.BaseItem{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+			Name: &name,
+			ParentReference: &msgraph.ItemReference{
+				DriveID: &driveID,
+				Path:    &parentPath,
+			},
+		},
+		Size: size,
+		Deleted: &msgraph.Deleted{
+			State: &state,
+		},
+	}
+	if item.DeletionTime != nil {
+		deleted := time.Unix(int64(item.DeletionTime.Seconds), int64(item.DeletionTime.Nanos))
+		driveItem.LastModifiedDateTime = &deleted
+	}
+	if item.Type == storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+		driveItem.File = &msgraph.File{}
+	}
+	if item.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		driveItem.Folder = &msgraph.Folder{}
+	}
+	return driveItem
+}
+
+// listRecycle returns the recycle items of the drive root.
+func listRecycle(ctx context.Context, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo) ([]*storageprovider.RecycleItem, error) {
+	res, err := client.ListRecycle(ctx, &gateway.ListRecycleRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: root.Path},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, &statusError{status: res.Status}
+	}
+	return res.RecycleItems, nil
+}
+
+// GetRecycleBin lists the deleted items of the drive.
+func (g Graph) GetRecycleBin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	items, err := listRecycle(ctx, client, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing recycle bin of %s", root.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	deleted := make([]*msgraph.DriveItem, 0, len(items))
+	for _, item := range items {
+		deleted = append(deleted, recycleItemToDriveItem(item, root))
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: deleted})
+}
+
+// RecycleItemCtx middleware is used to load a recycle item from the URL
+// parameters passed through as the request. In case the item could not
+// be found, we stop here and return a 404.
+func (g Graph) RecycleItemCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+		itemID := chi.URLParam(r, "recycleItemID")
+		key, err := base64.URLEncoding.DecodeString(itemID)
+		if err != nil || len(key) == 0 {
+			g.logger.Info().Err(err).Msgf("Invalid recycle item id %s", itemID)
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		items, err := listRecycle(ctx, client, root)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error listing recycle bin of %s", root.Path)
+			renderError(w, r, err)
+			return
+		}
+		for _, item := range items {
+			if item.Key == string(key) {
+				ctx = context.WithValue(ctx, recycleItemKey, item)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		g.logger.Info().Msgf("Failed to read recycle item %s", key)
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+	})
+}
+
+// RestoreRecycleItem restores a deleted item to its original location.
+func (g Graph) RestoreRecycleItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	item := ctx.Value(recycleItemKey).(*storageprovider.RecycleItem)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	fn := path.Join(root.Path, item.Path)
+	res, err := client.RestoreRecycleItem(ctx, &storageprovider.RestoreRecycleItemRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: root.Path},
+		},
+		Key: item.Key,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending restore recycle item grpc request %s", item.Key)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc restore recycle item %s", item.Key)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: fn},
+		},
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", fn)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", fn)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+
+	restored, err := cs3ResourceToDriveItem(statRes.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, restored)
+}
+
+// PurgeRecycleItem permanently deletes a single item from the recycle bin.
+// The item was looked up in the recycle bin by RecycleItemCtx, keys that
+// are not listed never reach the storage.
+func (g Graph) PurgeRecycleItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	item := ctx.Value(recycleItemKey).(*storageprovider.RecycleItem)
+
+	// the storage provider purges a single item if its key is sent as opaque
+	// id, without a key it empties the whole recycle bin
+	if item.Key == "" {
+		g.logger.Info().Msg("Refusing to purge a recycle item without key")
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return
+	}
+	g.purgeRecycle(w, r, &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Id{
+			Id: &storageprovider.ResourceId{
+				StorageId: root.Id.StorageId,
+				OpaqueId:  item.Key,
+			},
+		},
+	})
+}
+
+// EmptyRecycleBin permanently deletes all items from the recycle bin.
+func (g Graph) EmptyRecycleBin(w http.ResponseWriter, r *http.Request) {
+	root := r.Context().Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	g.purgeRecycle(w, r, &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: root.Path},
+	})
+}
+
+func (g Graph) purgeRecycle(w http.ResponseWriter, r *http.Request, ref *storageprovider.Reference) {
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.PurgeRecycle(r.Context(), &gateway.PurgeRecycleRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending purge recycle grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc purge recycle %s", ref)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
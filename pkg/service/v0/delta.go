@@ -0,0 +1,462 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// deltaTokenTTL defines how long a delta token can be used to fetch changes.
+const deltaTokenTTL = 7 * 24 * time.Hour
+
+// deltaPageSize defines how many changes are returned per page.
+const deltaPageSize = 200
+
+// deltaStorePrefix is the prefix of the store keys of delta snapshots.
+const deltaStorePrefix = "delta/"
+
+// deltaIndexPrefix is the prefix of the store keys of the delta tokens
+// issued to a user.
+const deltaIndexPrefix = "delta-index/"
+
+// deltaSnapshotsPerUser bounds the snapshots kept per user. Once exceeded,
+// the oldest tokens of the user are deleted and answered with resyncRequired.
+const deltaSnapshotsPerUser = 32
+
+// deltaEntry is the state of a single item when a delta token was issued.
+type deltaEntry struct {
+	Parent string `json:"parent"`
+	Path   string `json:"path"`
+	Etag   string `json:"etag"`
+	Folder bool   `json:"folder"`
+}
+
+// deltaSnapshot is the state of a tree when a delta token was issued. The
+// token is the key of the snapshot in the store. Tokens of next links refer
+// to the changes not returned yet instead, they are pending until the delta
+// token Next is returned.
+type deltaSnapshot struct {
+	ItemID     string                 `json:"item_id"`
+	Expiration time.Time              `json:"expiration"`
+	Entries    map[string]*deltaEntry `json:"entries,omitempty"`
+	Pending    []json.RawMessage      `json:"pending,omitempty"`
+	Next       string                 `json:"next,omitempty"`
+}
+
+// deltaIndex lists the delta tokens issued to a user, oldest first, and
+// the token of the latest full snapshot per item.
+type deltaIndex struct {
+	Tokens []string          `json:"tokens,omitempty"`
+	Latest map[string]string `json:"latest,omitempty"`
+}
+
+type deltaResponse struct {
+	Value     interface{} `json:"value"`
+	NextLink  string      `json:"@odata.nextLink,omitempty"`
+	DeltaLink string      `json:"@odata.deltaLink,omitempty"`
+}
+
+func deltaStoreKey(token string) string {
+	return deltaStorePrefix + token
+}
+
+// deltaSnapshotExpired checks the expiration encoded in the token of a
+// snapshot, so expired snapshots can be swept without reading them.
+func deltaSnapshotExpired(key string) bool {
+	return expiringTokenExpired(strings.TrimPrefix(key, deltaStorePrefix))
+}
+
+func (g Graph) readDeltaSnapshot(token string) (*deltaSnapshot, error) {
+	b, err := g.store.Read(deltaStoreKey(token))
+	if err != nil {
+		return nil, err
+	}
+	s := &deltaSnapshot{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeDeltaSnapshot stores a snapshot and adds its token to the index of
+// the user.
+func (g Graph) writeDeltaSnapshot(user string, s *deltaSnapshot) (string, error) {
+	token, err := newExpiringToken(s.Expiration)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	if err := g.store.Write(deltaStoreKey(token), b); err != nil {
+		return "", err
+	}
+	return token, g.indexDeltaToken(user, token, s)
+}
+
+func (g Graph) readDeltaIndex(user string) (*deltaIndex, error) {
+	idx := &deltaIndex{}
+	b, err := g.store.Read(deltaIndexPrefix + user)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, idx); err != nil {
+			return nil, err
+		}
+	}
+	if idx.Latest == nil {
+		idx.Latest = map[string]string{}
+	}
+	return idx, nil
+}
+
+// indexDeltaToken adds a token to the index of the user and deletes the
+// snapshots of expired tokens and of the oldest tokens above
+// deltaSnapshotsPerUser. Updates of the index are serialized per user on
+// this instance only, instances sharing a store can lose tokens from the
+// index, their snapshots are still removed by the sweep once expired.
+func (g Graph) indexDeltaToken(user, token string, s *deltaSnapshot) error {
+	unlock := g.deltaLocks.lock(user)
+	defer unlock()
+
+	idx, err := g.readDeltaIndex(user)
+	if err != nil {
+		return err
+	}
+	idx.Tokens = append(idx.Tokens, token)
+	if s.Next == "" {
+		idx.Latest[s.ItemID] = token
+	}
+
+	kept := make([]string, 0, len(idx.Tokens))
+	for i, t := range idx.Tokens {
+		if !deltaSnapshotExpired(t) && len(idx.Tokens)-i <= deltaSnapshotsPerUser {
+			kept = append(kept, t)
+			continue
+		}
+		if err := g.store.Delete(deltaStoreKey(t)); err != nil {
+			return err
+		}
+		for item, latest := range idx.Latest {
+			if latest == t {
+				delete(idx.Latest, item)
+			}
+		}
+	}
+	idx.Tokens = kept
+
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return g.store.Write(deltaIndexPrefix+user, b)
+}
+
+// latestDeltaSnapshot returns the token and the latest full snapshot of an
+// item issued to the user, or an empty token if there is none.
+func (g Graph) latestDeltaSnapshot(user, itemID string) (string, *deltaSnapshot, error) {
+	idx, err := g.readDeltaIndex(user)
+	if err != nil {
+		return "", nil, err
+	}
+	token, ok := idx.Latest[itemID]
+	if !ok {
+		return "", nil, nil
+	}
+	s, err := g.readDeltaSnapshot(token)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return "", nil, nil
+	case err != nil:
+		return "", nil, err
+	}
+	return token, s, nil
+}
+
+// deltaWalker compares a tree with a previous snapshot. The etag of a
+// folder changes whenever something below it changes, so folders with an
+// unchanged etag are taken over from the previous snapshot without
+// listing them again.
+type deltaWalker struct {
+	client   gateway.GatewayAPIClient
+	previous map[string]*deltaEntry
+	children map[string][]string
+	current  map[string]*deltaEntry
+	changed  []*storageprovider.ResourceInfo
+}
+
+func newDeltaWalker(client gateway.GatewayAPIClient, previous map[string]*deltaEntry) *deltaWalker {
+	children := map[string][]string{}
+	for id, e := range previous {
+		children[e.Parent] = append(children[e.Parent], id)
+	}
+	return &deltaWalker{
+		client:   client,
+		previous: previous,
+		children: children,
+		current:  map[string]*deltaEntry{},
+	}
+}
+
+func (d *deltaWalker) walk(ctx context.Context, info *storageprovider.ResourceInfo, parent string) error {
+	id := wrapResourceID(info.Id)
+	e := &deltaEntry{
+		Parent: parent,
+		Path:   info.Path,
+		Etag:   info.Etag,
+		Folder: info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER,
+	}
+	d.current[id] = e
+
+	if old, ok := d.previous[id]; ok && *old == *e {
+		if e.Folder {
+			d.keep(id)
+		}
+		return nil
+	}
+	d.changed = append(d.changed, info)
+	if !e.Folder {
+		return nil
+	}
+
+	res, err := d.client.ListContainer(ctx, &storageprovider.ListContainerRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: info.Path},
+		},
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		return err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: res.Status}
+	}
+	for _, child := range res.Infos {
+		if err := d.walk(ctx, child, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keep takes over the descendants of an unchanged folder.
+func (d *deltaWalker) keep(id string) {
+	for _, child := range d.children[id] {
+		d.current[child] = d.previous[child]
+		d.keep(child)
+	}
+}
+
+// deleted returns the items of the previous snapshot that are gone.
+func (d *deltaWalker) deleted(root *storageprovider.ResourceInfo) []*msgraph.DriveItem {
+	items := []*msgraph.DriveItem{}
+	for id, e := range d.previous {
+		if _, ok := d.current[id]; ok {
+			continue
+		}
+		id := id
+		name := path.Base(e.Path)
+		state := "deleted"
+		driveID := wrapResourceID(root.Id)
+		parentPath := parentReferencePath(driveID, root.Path, e.Path)
+		item := &msgraph.DriveItem{
+			BaseItem: msgraph.BaseItem{
+				Entity: msgraph.Entity{
+					ID: &id,
+				},
+				Name: &name,
+				ParentReference: &msgraph.ItemReference{
+					DriveID: &driveID,
+					Path:    &parentPath,
+				},
+			},
+			Deleted: &msgraph.Deleted{
+				State: &state,
+			},
+		}
+		if e.Folder {
+			item.Folder = &msgraph.Folder{}
+		} else {
+			item.File = &msgraph.File{}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// renderDeltaPage renders the first page of changes. The remaining changes
+// are stored for the next link, the delta link with the token next is
+// returned with the last page.
+func (g Graph) renderDeltaPage(w http.ResponseWriter, r *http.Request, itemID string, changes []json.RawMessage, next string) {
+	resp := &deltaResponse{Value: changes}
+	if len(changes) > deltaPageSize {
+		resp.Value = changes[:deltaPageSize]
+		token, err := g.writeDeltaSnapshot(revaUserID(r.Context()), &deltaSnapshot{
+			ItemID:     itemID,
+			Expiration: time.Now().Add(deltaTokenTTL),
+			Pending:    changes[deltaPageSize:],
+			Next:       next,
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msg("error writing delta page")
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		resp.NextLink = g.linkURL(r, url.Values{"token": []string{token}})
+	} else {
+		resp.DeltaLink = g.linkURL(r, url.Values{"token": []string{next}})
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
+
+// GetDriveItemDelta lists the items below the folder resolved by DriveItemCtx
+// that changed since the state the token parameter refers to. Without a
+// token all items are returned. The changes are returned in pages linked by
+// next links, the last page carries a delta link with a new token. If
+// nothing changed, the token of the previous state is returned again, as
+// long as it is valid for at least half of deltaTokenTTL,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-delta?view=graph-rest-1.0
+func (g Graph) GetDriveItemDelta(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	itemID := wrapResourceID(statRes.Info.Id)
+
+	user := revaUserID(ctx)
+	previous := map[string]*deltaEntry{}
+	var prev *deltaSnapshot
+	token := r.URL.Query().Get("token")
+	switch token {
+	case "":
+	case "latest":
+		// the client only wants a token for the current state, the latest
+		// snapshot is compared so an unchanged tree reuses its token
+		t, s, err := g.latestDeltaSnapshot(user, itemID)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error reading latest delta token of %s", itemID)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if s != nil && s.ItemID == itemID && time.Now().Before(s.Expiration) {
+			token, prev, previous = t, s, s.Entries
+		}
+	default:
+		s, err := g.readDeltaSnapshot(token)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			g.logger.Debug().Msgf("unknown delta token %s", token)
+			errorcode.ResyncRequired.Render(w, r, http.StatusGone)
+			return
+		case err != nil:
+			g.logger.Error().Err(err).Msgf("error reading delta token %s", token)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if s.ItemID != itemID || time.Now().After(s.Expiration) {
+			g.logger.Debug().Msgf("delta token %s expired or issued for another item", token)
+			if time.Now().After(s.Expiration) {
+				if err := g.store.Delete(deltaStoreKey(token)); err != nil {
+					g.logger.Error().Err(err).Msgf("error deleting delta token %s", token)
+				}
+			}
+			errorcode.ResyncRequired.Render(w, r, http.StatusGone)
+			return
+		}
+		if s.Next != "" {
+			// a next link, the changes were computed for the first page
+			g.renderDeltaPage(w, r, itemID, s.Pending, s.Next)
+			return
+		}
+		prev, previous = s, s.Entries
+	}
+
+	walker := newDeltaWalker(client, previous)
+	if err := walker.walk(ctx, statRes.Info, ""); err != nil {
+		g.logger.Error().Err(err).Msgf("error walking %s", statRes.Info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	deleted := walker.deleted(root)
+	if prev != nil && len(walker.changed) == 0 && len(deleted) == 0 && time.Until(prev.Expiration) > deltaTokenTTL/2 {
+		g.renderDeltaPage(w, r, itemID, []json.RawMessage{}, token)
+		return
+	}
+
+	changes := []json.RawMessage{}
+	if r.URL.Query().Get("token") != "latest" {
+		items, err := formatDriveItems(walker.changed, root)
+		if err == nil {
+			for _, item := range append(items, deleted...) {
+				var b []byte
+				if b, err = json.Marshal(item); err != nil {
+					break
+				}
+				changes = append(changes, b)
+			}
+		}
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	next, err := g.writeDeltaSnapshot(user, &deltaSnapshot{
+		ItemID:     itemID,
+		Expiration: time.Now().Add(deltaTokenTTL),
+		Entries:    walker.current,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msg("error writing delta token")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	g.renderDeltaPage(w, r, itemID, changes, next)
+}
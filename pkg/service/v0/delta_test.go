@@ -0,0 +1,82 @@
+package svc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/owncloud/ocis-graph/pkg/store"
+)
+
+func TestIndexDeltaToken(t *testing.T) {
+	g := Graph{store: store.NewMemory(), deltaLocks: newKeyLocks()}
+
+	var tokens []string
+	for i := 0; i <= deltaSnapshotsPerUser; i++ {
+		token, err := g.writeDeltaSnapshot("einstein", &deltaSnapshot{
+			ItemID:     "item",
+			Expiration: time.Now().Add(deltaTokenTTL),
+			Entries:    map[string]*deltaEntry{},
+		})
+		if err != nil {
+			t.Fatalf("writeDeltaSnapshot() error = %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if _, err := g.writeDeltaSnapshot("einstein", &deltaSnapshot{
+		ItemID:     "item",
+		Expiration: time.Now().Add(deltaTokenTTL),
+		Next:       tokens[len(tokens)-1],
+	}); err != nil {
+		t.Fatalf("writeDeltaSnapshot() error = %v", err)
+	}
+
+	for i, token := range tokens {
+		_, err := g.readDeltaSnapshot(token)
+		if evicted := i < 2; evicted != errors.Is(err, store.ErrNotFound) {
+			t.Errorf("readDeltaSnapshot(token %d) error = %v, want evicted %v", i, err, evicted)
+		}
+	}
+
+	idx, err := g.readDeltaIndex("einstein")
+	if err != nil {
+		t.Fatalf("readDeltaIndex() error = %v", err)
+	}
+	if len(idx.Tokens) != deltaSnapshotsPerUser {
+		t.Errorf("len(Tokens) = %d, want %d", len(idx.Tokens), deltaSnapshotsPerUser)
+	}
+
+	// next links do not replace the latest full snapshot
+	latest, _, err := g.latestDeltaSnapshot("einstein", "item")
+	if err != nil || latest != tokens[len(tokens)-1] {
+		t.Errorf("latestDeltaSnapshot() = %s, %v, want %s", latest, err, tokens[len(tokens)-1])
+	}
+	if latest, _, err := g.latestDeltaSnapshot("marie", "item"); err != nil || latest != "" {
+		t.Errorf("latestDeltaSnapshot() of another user = %s, %v, want none", latest, err)
+	}
+}
+
+func TestIndexDeltaTokenExpired(t *testing.T) {
+	g := Graph{store: store.NewMemory(), deltaLocks: newKeyLocks()}
+
+	expired, err := g.writeDeltaSnapshot("einstein", &deltaSnapshot{
+		ItemID:     "item",
+		Expiration: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("writeDeltaSnapshot() error = %v", err)
+	}
+	if _, err := g.writeDeltaSnapshot("einstein", &deltaSnapshot{
+		ItemID:     "other",
+		Expiration: time.Now().Add(deltaTokenTTL),
+	}); err != nil {
+		t.Fatalf("writeDeltaSnapshot() error = %v", err)
+	}
+
+	if _, err := g.readDeltaSnapshot(expired); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("readDeltaSnapshot(expired) error = %v, want %v", err, store.ErrNotFound)
+	}
+	if latest, _, err := g.latestDeltaSnapshot("einstein", "item"); err != nil || latest != "" {
+		t.Errorf("latestDeltaSnapshot() = %s, %v, want none", latest, err)
+	}
+}
@@ -1,17 +1,27 @@
 package svc
 
 import (
-	"github.com/go-chi/render"
-	"google.golang.org/grpc/metadata"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
 	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
 	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
 	"github.com/cs3org/reva/pkg/token"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
 	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+	"google.golang.org/grpc/metadata"
 )
 
 func getToken(r *http.Request) string {
@@ -32,73 +42,512 @@ func getToken(r *http.Request) string {
 	return tokens[0]
 }
 
-// GetRootDriveChildren implements the Service interface.
-func (g Graph) GetRootDriveChildren(w http.ResponseWriter, r *http.Request) {
-	g.logger.Info().Msgf("Calling GetRootDriveChildren")
-	accessToken := getToken(r)
-	if accessToken == "" {
-		g.logger.Error().Msg("no access token provided in request")
-		w.WriteHeader(http.StatusForbidden)
-		return
+// withRevaToken returns a context that carries the reva token to the gateway.
+func withRevaToken(ctx context.Context, t string) context.Context {
+	ctx = token.ContextSetToken(ctx, t)
+	return metadata.AppendToOutgoingContext(ctx, "x-access-token", t)
+}
+
+// revaUser returns the user authenticated by RevaCtx.
+func revaUser(ctx context.Context) *userv1beta1.User {
+	u, _ := ctx.Value(revaUserKey).(*userv1beta1.User)
+	return u
+}
+
+// revaUserID returns the opaque id of the user authenticated by RevaCtx.
+func revaUserID(ctx context.Context) string {
+	if u := revaUser(ctx); u != nil && u.Id != nil {
+		return u.Id.OpaqueId
 	}
-	ctx := r.Context()
+	return ""
+}
+
+// RevaCtx middleware is used to authenticate the request against the
+// CS3 gateway. The reva token and user are stored in the request context,
+// so the handlers below can talk to the gateway on behalf of the user.
+func (g Graph) RevaCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessToken := getToken(r)
+		if accessToken == "" {
+			g.logger.Error().Msg("no access token provided in request")
+			errorcode.Unauthenticated.Render(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		// get reva token
+		authReq := &gateway.AuthenticateRequest{
+			Type:         "bearer",
+			ClientSecret: accessToken,
+		}
+		authRes, err := client.Authenticate(r.Context(), authReq)
+		if err != nil {
+			g.logger.Err(err).Msg("error sending authenticate grpc request")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if authRes.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Error().Str("message", authRes.Status.Message).Msg("could not authenticate against reva")
+			errorcode.Unauthenticated.Render(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), revaUserKey, authRes.User)
+		next.ServeHTTP(w, r.WithContext(withRevaToken(ctx, authRes.Token)))
+	})
+}
+
+// DriveCtx middleware is used to load the root of the addressed drive. It
+// expects the reva token set by RevaCtx. /me/drive is backed by the home of
+// the user, /drives/{driveID} by the resource the drive id points to. That
+// resource has to be the home of the user or one of the configured project
+// drives, ids of other items are not found.
+func (g Graph) DriveCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		var ref *storageprovider.Reference
+		if driveID := chi.URLParam(r, "driveID"); driveID != "" {
+			id := unwrapResourceID(driveID)
+			if id == nil {
+				g.logger.Info().Msgf("Invalid drive id %s", driveID)
+				errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+				return
+			}
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Id{Id: id},
+			}
+		} else {
+			homeRes, err := client.GetHome(ctx, &storageprovider.GetHomeRequest{})
+			if err != nil {
+				g.logger.Error().Err(err).Msg("error sending get home grpc request")
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			if homeRes.Status.Code != cs3rpc.Code_CODE_OK {
+				g.logger.Debug().Str("code", homeRes.Status.Code.String()).Msg("error calling grpc get home")
+				renderStatus(w, r, homeRes.Status)
+				return
+			}
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: homeRes.Path},
+			}
+		}
+
+		statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+			renderStatus(w, r, statRes.Status)
+			return
+		}
+
+		if chi.URLParam(r, "driveID") != "" && !g.isProjectRoot(statRes.Info.Path) {
+			home, err := getHomePath(ctx, client)
+			if err != nil {
+				g.logger.Error().Err(err).Msg("error getting home")
+				renderError(w, r, err)
+				return
+			}
+			if statRes.Info.Path != home {
+				g.logger.Debug().Msgf("%s is not a drive", statRes.Info.Path)
+				errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+				return
+			}
+		}
+
+		ctx = context.WithValue(ctx, driveRootKey, statRes.Info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PathCtx middleware rewrites the path based addressing of MS Graph, e.g.
+// root:/Documents/report.pdf:/content or items/{itemID}:/report.pdf:/content,
+// into the id based routes. The relative path is stored in the request
+// context and resolved by DriveItemCtx.
+func (g Graph) PathCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.RouteContext(r.Context())
+		routePath := rctx.RoutePath
+		if routePath == "" {
+			routePath = routingPath(r)
+		}
+
+		i := strings.Index(routePath, ":")
+		if i < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		base, rest := routePath[:i], routePath[i+1:]
+		// only a colon right after the item starts a path, e.g. not in search(q='a:b')
+		if base != "/root" && (!strings.HasPrefix(base, "/items/") || strings.Contains(strings.TrimPrefix(base, "/items/"), "/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		action := ""
+		if j := strings.LastIndex(rest, ":/"); j >= 0 {
+			action = rest[j+1:]
+		}
+
+		// the item path is cut from the escaped path, so it is unescaped once
+		// no matter if chi routes the raw or the decoded path
+		escaped := escapedSuffix(r, routePath)
+		rel := strings.TrimSuffix(escaped[strings.Index(escaped, ":")+1:], ":")
+		if action != "" {
+			rel = rel[:strings.LastIndex(rel, ":/")]
+		}
+		rel, err := url.PathUnescape(rel)
+		if err != nil {
+			g.logger.Info().Err(err).Msgf("Invalid item path %s", rel)
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+
+		rctx.RoutePath = base + action
+		ctx := context.WithValue(r.Context(), driveItemPathKey, path.Clean("/"+rel))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// DriveItemCtx middleware is used to resolve a drive item from the URL
+// parameters passed through as the request. Items are addressed by their
+// id or relative to the drive root, optionally followed by a path set by
+// PathCtx. In case the item could not be found, we stop here and return
+// a 404.
+func (g Graph) DriveItemCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+		rel, _ := ctx.Value(driveItemPathKey).(string)
+
+		var ref *storageprovider.Reference
+		itemID := chi.URLParam(r, "itemID")
+		switch {
+		case itemID == "":
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: path.Join(root.Path, rel)},
+			}
+		default:
+			id := unwrapResourceID(itemID)
+			if id == nil {
+				g.logger.Info().Msgf("Invalid drive item id %s", itemID)
+				errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+				return
+			}
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Id{Id: id},
+			}
+			if rel == "" {
+				break
+			}
+
+			client, err := g.GetClient()
+			if err != nil {
+				g.logger.Err(err).Msg("error getting grpc client")
+				errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			res, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			if res.Status.Code != cs3rpc.Code_CODE_OK {
+				g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+				renderStatus(w, r, res.Status)
+				return
+			}
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: path.Join(res.Info.Path, rel)},
+			}
+		}
+
+		ctx = context.WithValue(ctx, driveItemKey, ref)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	fn := "/home"
+// GetDriveItem returns the metadata of the drive item resolved by DriveItemCtx.
+func (g Graph) GetDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
 
 	client, err := g.GetClient()
 	if err != nil {
 		g.logger.Err(err).Msg("error getting grpc client")
-		w.WriteHeader(http.StatusInternalServerError)
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	// get reva token
-	authReq := &gateway.AuthenticateRequest{
-		Type:         "bearer",
-		ClientSecret: accessToken,
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, res.Status)
+		return
 	}
 
-	authRes, _ := client.Authenticate(ctx, authReq);
-	ctx = token.ContextSetToken(ctx, authRes.Token)
-	ctx = metadata.AppendToOutgoingContext(ctx, "x-access-token", authRes.Token)
+	item, err := cs3ResourceToDriveItem(res.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
 
-	g.logger.Info().Msgf("provides access token %v", ctx)
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, withLock(item, res.Info))
+}
+
+// GetDriveItemChildren lists the children of the folder resolved by DriveItemCtx.
+func (g Graph) GetDriveItemChildren(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
 
-	ref := &storageprovider.Reference{
-		Spec: &storageprovider.Reference_Path{Path: fn},
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
 	}
 
-	req := &storageprovider.ListContainerRequest{
-		Ref: ref,
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
 	}
-	res, err := client.ListContainer(ctx, req)
+
+	res, err := client.ListContainer(ctx, &storageprovider.ListContainerRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
 	if err != nil {
-		g.logger.Error().Err(err).Msgf("error sending list container grpc request %s", fn)
-		w.WriteHeader(http.StatusInternalServerError)
+		g.logger.Error().Err(err).Msgf("error sending list container grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
 		return
 	}
 	if res.Status.Code != cs3rpc.Code_CODE_OK {
-		g.logger.Error().Err(err).Msgf("error calling grpc list container %s", fn)
-		w.WriteHeader(http.StatusInternalServerError)
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc list container %s", ref)
+		renderStatus(w, r, res.Status)
 		return
 	}
 
-	files, err := formatDriveItems(res.Infos)
+	files, err := formatDriveItems(res.Infos, root)
 	if err != nil {
 		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
 		return
 	}
 
+	parentID := wrapResourceID(statRes.Info.Id)
+	items := make([]*lockedDriveItem, 0, len(files))
+	for i, f := range files {
+		f.ParentReference.ID = &parentID
+		items = append(items, withLock(f, res.Infos[i]))
+	}
+
 	render.Status(r, http.StatusOK)
-	render.JSON(w, r, &listResponse{Value: files})
+	render.JSON(w, r, &listResponse{Value: items})
+}
+
+// statusErrorCode translates a non ok CS3 status into a Graph error code
+// and http status.
+func statusErrorCode(status *cs3rpc.Status) (errorcode.ErrorCode, int) {
+	switch status.Code {
+	case cs3rpc.Code_CODE_NOT_FOUND:
+		return errorcode.ItemNotFound, http.StatusNotFound
+	case cs3rpc.Code_CODE_ALREADY_EXISTS:
+		return errorcode.NameAlreadyExists, http.StatusConflict
+	case cs3rpc.Code_CODE_PERMISSION_DENIED:
+		return errorcode.AccessDenied, http.StatusForbidden
+	case cs3rpc.Code_CODE_UNAUTHENTICATED:
+		return errorcode.Unauthenticated, http.StatusUnauthorized
+	case cs3rpc.Code_CODE_INVALID_ARGUMENT:
+		return errorcode.InvalidRequest, http.StatusBadRequest
+	case cs3rpc.Code_CODE_UNIMPLEMENTED:
+		return errorcode.NotSupported, http.StatusNotImplemented
+	default:
+		return errorcode.GeneralException, http.StatusInternalServerError
+	}
+}
+
+// renderStatus translates a non ok CS3 status into a Graph error response.
+func renderStatus(w http.ResponseWriter, r *http.Request, status *cs3rpc.Status) {
+	code, httpStatus := statusErrorCode(status)
+	code.Render(w, r, httpStatus)
+}
+
+// statusError wraps a non ok CS3 status, so helpers can hand it to the
+// handler that renders the response.
+type statusError struct {
+	status *cs3rpc.Status
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.status.Code, e.status.Message)
+}
+
+// errInvalidReference is returned if a reference in a request can not be resolved.
+var errInvalidReference = errors.New("invalid item reference")
+
+// errorCode translates a statusError with statusErrorCode, an invalid
+// reference into invalid request, an exceeded quota into quota limit reached
+// and everything else into general exception.
+func errorCode(err error) (errorcode.ErrorCode, int) {
+	if se, ok := err.(*statusError); ok {
+		return statusErrorCode(se.status)
+	}
+	switch err {
+	case errInvalidReference:
+		return errorcode.InvalidRequest, http.StatusBadRequest
+	case errQuotaExceeded:
+		return errorcode.QuotaLimitReached, http.StatusInsufficientStorage
+	case errNotAFolder:
+		return errorcode.NameAlreadyExists, http.StatusConflict
+	case errLocked:
+		return errorcode.NotAllowed, http.StatusForbidden
+	}
+	return errorcode.GeneralException, http.StatusInternalServerError
+}
+
+// renderError translates an error into a Graph error response, see errorCode.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	code, status := errorCode(err)
+	code.Render(w, r, status)
+}
+
+// conflictBehavior defines how to handle name conflicts when creating items,
+// see https://docs.microsoft.com/en-us/graph/api/resources/driveitem?view=graph-rest-1.0#instance-attributes
+type conflictBehavior string
+
+const (
+	conflictBehaviorFail    conflictBehavior = "fail"
+	conflictBehaviorReplace conflictBehavior = "replace"
+	conflictBehaviorRename  conflictBehavior = "rename"
+)
+
+// parseConflictBehavior validates the given behavior and falls back to def if it is empty.
+func parseConflictBehavior(s string, def conflictBehavior) (conflictBehavior, error) {
+	switch cb := conflictBehavior(s); cb {
+	case "":
+		return def, nil
+	case conflictBehaviorFail, conflictBehaviorReplace, conflictBehaviorRename:
+		return cb, nil
+	default:
+		return "", fmt.Errorf("unknown conflict behavior %s", s)
+	}
+}
+
+// findAvailablePath returns a path next to fn that does not exist yet by
+// appending " 1", " 2"... to the name, keeping the extension of files.
+func findAvailablePath(ctx context.Context, client gateway.GatewayAPIClient, fn string, isFile bool) (string, error) {
+	dir, name := path.Split(fn)
+	ext := ""
+	if isFile {
+		ext = path.Ext(name)
+	}
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := path.Join(dir, fmt.Sprintf("%s %d%s", base, i, ext))
+		res, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: candidate},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		switch res.Status.Code {
+		case cs3rpc.Code_CODE_NOT_FOUND:
+			return candidate, nil
+		case cs3rpc.Code_CODE_OK:
+			continue
+		default:
+			return "", fmt.Errorf("error calling grpc stat %s: %s", candidate, res.Status.Message)
+		}
+	}
 }
 
+// wrapResourceID encodes a CS3 resource id into a single url safe string.
+// It uses the same scheme as the reva ocdav service, so ids can be shared
+// between both apis.
+func wrapResourceID(id *storageprovider.ResourceId) string {
+	return base64.URLEncoding.EncodeToString([]byte(id.StorageId + ":" + id.OpaqueId))
+}
+
+// unwrapResourceID decodes an id created by wrapResourceID. It returns nil
+// if the id is not valid.
+func unwrapResourceID(id string) *storageprovider.ResourceId {
+	decoded, err := base64.URLEncoding.DecodeString(id)
+	if err != nil {
+		return nil
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 || !utf8.ValidString(parts[0]) || !utf8.ValidString(parts[1]) {
+		return nil
+	}
+	return &storageprovider.ResourceId{
+		StorageId: parts[0],
+		OpaqueId:  parts[1],
+	}
+}
 
-func cs3ResourceToDriveItem(res *storageprovider.ResourceInfo) (*msgraph.DriveItem, error) {
+// parentReferencePath returns the path of the parent reference of the item
+// at p in the drive with the given id and root path, e.g.
+// /drives/{driveID}/root:/Documents.
+func parentReferencePath(driveID, rootPath, p string) string {
+	dir := path.Dir(p)
+	if dir == rootPath {
+		return "/drives/" + driveID + "/root:"
+	}
+	return "/drives/" + driveID + "/root:/" + strings.TrimPrefix(strings.TrimPrefix(dir, rootPath), "/")
+}
+
+// cs3ResourceToDriveItem converts a CS3 resource into a drive item. The
+// name and the parent reference are derived from the path of the resource
+// relative to the root of the drive it belongs to.
+func cs3ResourceToDriveItem(res *storageprovider.ResourceInfo, root *storageprovider.ResourceInfo) (*msgraph.DriveItem, error) {
 	size := new(int)
 	*size = int(res.Size) // uint64 -> int :boom:
-	name := strings.TrimPrefix(res.Path, "/home/")
+	name := path.Base(res.Path)
+	id := wrapResourceID(res.Id)
 	lastModified := new(time.Time)
 	*lastModified = time.Unix(int64(res.Mtime.Seconds), int64(res.Mtime.Nanos))
 
@@ -106,30 +555,41 @@ func cs3ResourceToDriveItem(res *storageprovider.ResourceInfo) (*msgraph.DriveIt
 		BaseItem: msgraph.BaseItem{
 			Entity: msgraph.Entity{
 				Object: msgraph.Object{},
-				ID:     &res.Id.OpaqueId,
+				ID:     &id,
 			},
-			Name: &name,
+			Name:                 &name,
 			LastModifiedDateTime: lastModified,
-			ETag: &res.Etag,
+			ETag:                 &res.Etag,
 		},
 		Size: size,
 	}
+	if res.Path == root.Path {
+		name = "root"
+		driveItem.Root = &msgraph.Root{}
+	} else {
+		driveID := wrapResourceID(root.Id)
+		parentPath := parentReferencePath(driveID, root.Path, res.Path)
+		driveItem.ParentReference = &msgraph.ItemReference{
+			DriveID: &driveID,
+			Path:    &parentPath,
+		}
+	}
 	if res.Type == storageprovider.ResourceType_RESOURCE_TYPE_FILE {
 		driveItem.File = &msgraph.File{
 			MimeType: &res.MimeType,
+			Hashes:   cs3ChecksumsToHashes(res),
 		}
 	}
 	if res.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
-		driveItem.Folder = &msgraph.Folder{
-		}
+		driveItem.Folder = &msgraph.Folder{}
 	}
 	return driveItem, nil
 }
 
-func formatDriveItems(mds []*storageprovider.ResourceInfo) ([]*msgraph.DriveItem, error) {
+func formatDriveItems(mds []*storageprovider.ResourceInfo, root *storageprovider.ResourceInfo) ([]*msgraph.DriveItem, error) {
 	responses := make([]*msgraph.DriveItem, 0, len(mds))
 	for i := range mds {
-		res, err := cs3ResourceToDriveItem(mds[i])
+		res, err := cs3ResourceToDriveItem(mds[i], root)
 		if err != nil {
 			return nil, err
 		}
@@ -138,3 +598,194 @@ func formatDriveItems(mds []*storageprovider.ResourceInfo) ([]*msgraph.DriveItem
 
 	return responses, nil
 }
+
+// getHomePath returns the path of the home of the user.
+func getHomePath(ctx context.Context, client gateway.GatewayAPIClient) (string, error) {
+	res, err := client.GetHome(ctx, &storageprovider.GetHomeRequest{})
+	if err != nil {
+		return "", err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return "", &statusError{status: res.Status}
+	}
+	return res.Path, nil
+}
+
+// listDriveRoots returns the roots of the drives of the user: the home
+// first, followed by the configured project drives the user can access.
+func (g Graph) listDriveRoots(ctx context.Context, client gateway.GatewayAPIClient) ([]*storageprovider.ResourceInfo, error) {
+	home, err := getHomePath(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{home}
+	for _, p := range g.config.Drives.Projects {
+		if p = path.Clean(p); p != home {
+			paths = append(paths, p)
+		}
+	}
+
+	roots := make([]*storageprovider.ResourceInfo, 0, len(paths))
+	for i, p := range paths {
+		res, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: p},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			if i == 0 {
+				return nil, &statusError{status: res.Status}
+			}
+			// project drives the user has no access to are not listed
+			g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", p)
+			continue
+		}
+		roots = append(roots, res.Info)
+	}
+	return roots, nil
+}
+
+// cs3RootToDrive converts the root of a drive into a drive. The id of the
+// drive is the id of its root item.
+func cs3RootToDrive(root *storageprovider.ResourceInfo, driveType string) (*msgraph.Drive, error) {
+	rootItem, err := cs3ResourceToDriveItem(root, root)
+	if err != nil {
+		return nil, err
+	}
+	id := wrapResourceID(root.Id)
+	name := path.Base(root.Path)
+
+	drive := &msgraph.Drive{
+		BaseItem: msgraph.BaseItem{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+			Name:                 &name,
+			LastModifiedDateTime: rootItem.LastModifiedDateTime,
+		},
+		DriveType: &driveType,
+		Root:      rootItem,
+	}
+	if root.Owner != nil {
+		ownerID := root.Owner.OpaqueId
+		drive.Owner = &msgraph.IdentitySet{
+			User: &msgraph.Identity{
+				ID: &ownerID,
+			},
+		}
+	}
+	return drive, nil
+}
+
+// withQuota sets the quota facet of a drive. Storages that do not report
+// a quota are rendered without the facet.
+func (g Graph) withQuota(ctx context.Context, client gateway.GatewayAPIClient, drive *msgraph.Drive, root *storageprovider.ResourceInfo) error {
+	total, used, err := getQuota(ctx, client, root)
+	if err != nil {
+		if se, ok := err.(*statusError); ok {
+			g.logger.Debug().Str("code", se.status.Code.String()).Msgf("error calling grpc get quota %s", root.Path)
+			return nil
+		}
+		return err
+	}
+	drive.Quota = cs3QuotaToGraph(total, used)
+	return nil
+}
+
+// driveType returns personal for the home of the user and project for
+// the configured project drives.
+func driveType(root *storageprovider.ResourceInfo, home string) string {
+	if root.Path == home {
+		return "personal"
+	}
+	return "project"
+}
+
+// isProjectRoot tells whether p is the root of a configured project drive.
+func (g Graph) isProjectRoot(p string) bool {
+	for _, project := range g.config.Drives.Projects {
+		if path.Clean(project) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDrives lists the drives of the user,
+// see https://docs.microsoft.com/en-us/graph/api/drive-list?view=graph-rest-1.0
+func (g Graph) GetDrives(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	roots, err := g.listDriveRoots(ctx, client)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("error listing drives")
+		renderError(w, r, err)
+		return
+	}
+
+	drives := make([]*msgraph.Drive, 0, len(roots))
+	for _, root := range roots {
+		// listDriveRoots returns the home first
+		drive, err := cs3RootToDrive(root, driveType(root, roots[0].Path))
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if err := g.withQuota(ctx, client, drive, root); err != nil {
+			g.logger.Error().Err(err).Msgf("error sending get quota grpc request %s", root.Path)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		drives = append(drives, drive)
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: drives})
+}
+
+// GetDrive returns the drive resolved by DriveCtx,
+// see https://docs.microsoft.com/en-us/graph/api/drive-get?view=graph-rest-1.0
+func (g Graph) GetDrive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	home, err := getHomePath(ctx, client)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("error getting home")
+		renderError(w, r, err)
+		return
+	}
+
+	drive, err := cs3RootToDrive(root, driveType(root, home))
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if err := g.withQuota(ctx, client, drive, root); err != nil {
+		g.logger.Error().Err(err).Msgf("error sending get quota grpc request %s", root.Path)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, drive)
+}
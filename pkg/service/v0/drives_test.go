@@ -0,0 +1,117 @@
+package svc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/owncloud/ocis-pkg/v2/log"
+)
+
+func TestPathCtx(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		routePath string
+		wantRoute string
+		wantPath  string
+	}{
+		{
+			name:      "item path with action",
+			target:    "/root:/Documents/report.pdf:/content",
+			wantRoute: "/root/content",
+			wantPath:  "/Documents/report.pdf",
+		},
+		{
+			name:      "item path",
+			target:    "/root:/Documents:",
+			wantRoute: "/root",
+			wantPath:  "/Documents",
+		},
+		{
+			name:      "relative to an item",
+			target:    "/items/abc:/a%20b.txt",
+			wantRoute: "/items/abc",
+			wantPath:  "/a b.txt",
+		},
+		{
+			name:      "escaped percent",
+			target:    "/root:/100%25.txt:",
+			wantRoute: "/root",
+			wantPath:  "/100%.txt",
+		},
+		{
+			name:      "unescaped once",
+			target:    "/root:/%2541:/content",
+			wantRoute: "/root/content",
+			wantPath:  "/%41",
+		},
+		{
+			name:      "escaped slash",
+			target:    "/root:/a%2Fb:/content",
+			wantRoute: "/root/content",
+			wantPath:  "/a/b",
+		},
+		{
+			name:      "escaped colon",
+			target:    "/root:/a%3Ab.txt:/content",
+			wantRoute: "/root/content",
+			wantPath:  "/a:b.txt",
+		},
+		{
+			name:      "mounted",
+			target:    "/graph/v1.0/me/drive/root:/a%20b%25:/children",
+			routePath: "/root:/a b%:/children",
+			wantRoute: "/root/children",
+			wantPath:  "/a b%",
+		},
+		{
+			name:      "trailing slash",
+			target:    "/graph/v1.0/me/drive/root:/Documents:/children/",
+			routePath: "/root:/Documents:/children",
+			wantRoute: "/root/children",
+			wantPath:  "/Documents",
+		},
+		{
+			name:      "no item path",
+			target:    "/root/children",
+			wantRoute: "",
+		},
+		{
+			name:      "colon in search",
+			target:    "/root/search(q='a:b')",
+			wantRoute: "",
+		},
+	}
+
+	logger := log.NewLogger(log.Level("fatal"))
+	g := Graph{logger: &logger}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotRoute, gotPath string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotRoute = chi.RouteContext(r.Context()).RoutePath
+				gotPath, _ = r.Context().Value(driveItemPathKey).(string)
+			})
+
+			rctx := chi.NewRouteContext()
+			rctx.RoutePath = tt.routePath
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+			g.PathCtx(next).ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("PathCtx() status = %d", w.Code)
+			}
+			if gotRoute != tt.wantRoute {
+				t.Errorf("PathCtx() route path = %q, want %q", gotRoute, tt.wantRoute)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("PathCtx() item path = %q, want %q", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+)
+
+const (
+	// extensionIndexKey lists the names of the extensions of a resource.
+	// CS3 can not list arbitrary metadata keys, so the names are kept in a
+	// key of their own.
+	extensionIndexKey = "http://owncloud.org/ns/graph/extensions"
+	// extensionKeyPrefix followed by the extension name stores the
+	// properties of an extension as JSON object.
+	extensionKeyPrefix = extensionIndexKey + "/"
+)
+
+// errInvalidExtension is returned for extensions without a valid name.
+var errInvalidExtension = errors.New("invalid extension")
+
+// errExtensionExists is returned when an extension is created twice.
+var errExtensionExists = errors.New("extension exists")
+
+// extensionNamePattern restricts the extension names, usually reverse DNS
+// names like com.example.records.
+var extensionNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+// extension is an open extension of a drive item,
+// see https://docs.microsoft.com/en-us/graph/api/resources/opentypeextension?view=graph-rest-1.0
+type extension struct {
+	name  string
+	props map[string]interface{}
+}
+
+// MarshalJSON adds the name and type of the extension to its properties.
+func (e *extension) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(e.props)+3)
+	for k, v := range e.props {
+		m[k] = v
+	}
+	m["@odata.type"] = "#microsoft.graph.openTypeExtension"
+	m["id"] = e.name
+	m["extensionName"] = e.name
+	return json.Marshal(m)
+}
+
+// decodeExtension reads an extension from a request body. The name is
+// taken from the body if name is empty.
+func decodeExtension(r *http.Request, name string) (*extension, error) {
+	props := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&props); err != nil {
+		return nil, err
+	}
+	if n, ok := props["extensionName"].(string); ok && name == "" {
+		name = n
+	}
+	if !extensionNamePattern.MatchString(name) {
+		return nil, errInvalidExtension
+	}
+	delete(props, "@odata.type")
+	delete(props, "id")
+	delete(props, "extensionName")
+	return &extension{name: name, props: props}, nil
+}
+
+// merge applies the properties of update to e. Properties set to null are
+// removed.
+func (e *extension) merge(update *extension) {
+	for k, v := range update.props {
+		if v == nil {
+			delete(e.props, k)
+			continue
+		}
+		e.props[k] = v
+	}
+}
+
+// extensionNames returns the sorted names of the extensions of a resource
+// stated with extensionIndexKey.
+func extensionNames(info *storageprovider.ResourceInfo) []string {
+	names := []string{}
+	if info.ArbitraryMetadata == nil {
+		return names
+	}
+	if v := info.ArbitraryMetadata.Metadata[extensionIndexKey]; v != "" {
+		if err := json.Unmarshal([]byte(v), &names); err != nil {
+			return []string{}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// statExtensions stats ref with the given metadata keys.
+func statExtensions(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference, keys []string) (*storageprovider.ResourceInfo, error) {
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, &statusError{status: res.Status}
+	}
+	return res.Info, nil
+}
+
+// listExtensions returns the extensions of ref, sorted by name.
+func listExtensions(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference) ([]*extension, error) {
+	info, err := statExtensions(ctx, client, ref, []string{extensionIndexKey})
+	if err != nil {
+		return nil, err
+	}
+	names := extensionNames(info)
+	if len(names) == 0 {
+		return []*extension{}, nil
+	}
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, extensionKeyPrefix+name)
+	}
+	if info, err = statExtensions(ctx, client, ref, keys); err != nil {
+		return nil, err
+	}
+
+	extensions := make([]*extension, 0, len(names))
+	for _, name := range names {
+		e := &extension{name: name, props: map[string]interface{}{}}
+		if info.ArbitraryMetadata != nil {
+			if v, ok := info.ArbitraryMetadata.Metadata[extensionKeyPrefix+name]; ok {
+				if err := json.Unmarshal([]byte(v), &e.props); err != nil {
+					return nil, err
+				}
+			}
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions, nil
+}
+
+// readExtensionNames returns the names of the extensions of ref before they
+// are changed. It returns errLocked if ref is checked out by another user.
+func readExtensionNames(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference) ([]string, error) {
+	info, err := statExtensions(ctx, client, ref, []string{extensionIndexKey, lockMetadataKey})
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLock(ctx, info); err != nil {
+		return nil, err
+	}
+	return extensionNames(info), nil
+}
+
+// writeExtension stores an extension of ref and adds it to the index. With
+// create it returns errExtensionExists if the extension is in the index.
+// The index is read right before it is written, so concurrent changes of
+// other extensions are kept. CS3 has no conditional metadata updates,
+// changes between the read and the write can still be lost.
+func writeExtension(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference, e *extension, create bool) error {
+	props, err := json.Marshal(e.props)
+	if err != nil {
+		return err
+	}
+	names, err := readExtensionNames(ctx, client, ref)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, name := range names {
+		found = found || name == e.name
+	}
+	if found && create {
+		return errExtensionExists
+	}
+	if !found {
+		names = append(names, e.name)
+	}
+	index, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.SetArbitraryMetadata(ctx, &storageprovider.SetArbitraryMetadataRequest{
+		Ref: ref,
+		ArbitraryMetadata: &storageprovider.ArbitraryMetadata{
+			Metadata: map[string]string{
+				extensionKeyPrefix + e.name: string(props),
+				extensionIndexKey:           string(index),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: res.Status}
+	}
+	return nil
+}
+
+// removeExtension deletes an extension of ref and removes it from the
+// index. Like writeExtension it reads the index right before writing it.
+func removeExtension(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference, name string) error {
+	names, err := readExtensionNames(ctx, client, ref)
+	if err != nil {
+		return err
+	}
+	remaining := []string{}
+	for _, n := range names {
+		if n != name {
+			remaining = append(remaining, n)
+		}
+	}
+	index, err := json.Marshal(remaining)
+	if err != nil {
+		return err
+	}
+
+	setRes, err := client.SetArbitraryMetadata(ctx, &storageprovider.SetArbitraryMetadataRequest{
+		Ref: ref,
+		ArbitraryMetadata: &storageprovider.ArbitraryMetadata{
+			Metadata: map[string]string{extensionIndexKey: string(index)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if setRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: setRes.Status}
+	}
+
+	unsetRes, err := client.UnsetArbitraryMetadata(ctx, &storageprovider.UnsetArbitraryMetadataRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: []string{extensionKeyPrefix + name},
+	})
+	if err != nil {
+		return err
+	}
+	if unsetRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: unsetRes.Status}
+	}
+	return nil
+}
+
+// GetExtensions lists the open extensions of the item resolved by DriveItemCtx.
+func (g Graph) GetExtensions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	extensions, err := listExtensions(ctx, client, ref)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing extensions of %s", ref)
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: extensions})
+}
+
+// CreateExtension adds an open extension to the item resolved by DriveItemCtx,
+// see https://docs.microsoft.com/en-us/graph/api/opentypeextension-post-opentypeextension?view=graph-rest-1.0
+func (g Graph) CreateExtension(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	e, err := decodeExtension(r, "")
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode extension")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	switch err := writeExtension(ctx, client, ref, e, true); {
+	case err == errExtensionExists:
+		errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+		return
+	case err != nil:
+		g.logger.Error().Err(err).Msgf("error writing extension %s of %s", e.name, ref)
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, e)
+}
+
+// ExtensionCtx middleware is used to load an open extension of the item
+// resolved by DriveItemCtx from the URL parameters passed through as the
+// request. In case the extension could not be found, we stop here and
+// return a 404.
+func (g Graph) ExtensionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+		name := chi.URLParam(r, "extensionName")
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		extensions, err := listExtensions(ctx, client, ref)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error listing extensions of %s", ref)
+			renderError(w, r, err)
+			return
+		}
+
+		for _, e := range extensions {
+			if e.name == name {
+				ctx = context.WithValue(ctx, extensionKey, e)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		g.logger.Info().Msgf("Failed to read extension %s of %s", name, ref)
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+	})
+}
+
+// GetExtension returns the open extension resolved by ExtensionCtx.
+func (g Graph) GetExtension(w http.ResponseWriter, r *http.Request) {
+	e := r.Context().Value(extensionKey).(*extension)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, e)
+}
+
+// UpdateExtension merges the request body into the properties of the open
+// extension resolved by ExtensionCtx. Properties set to null are removed,
+// see https://docs.microsoft.com/en-us/graph/api/opentypeextension-update?view=graph-rest-1.0
+func (g Graph) UpdateExtension(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+	e := ctx.Value(extensionKey).(*extension)
+
+	update, err := decodeExtension(r, e.name)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode extension")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	e.merge(update)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeExtension(ctx, client, ref, e, false); err != nil {
+		g.logger.Error().Err(err).Msgf("error writing extension %s of %s", e.name, ref)
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, e)
+}
+
+// DeleteExtension removes the open extension resolved by ExtensionCtx,
+// see https://docs.microsoft.com/en-us/graph/api/opentypeextension-delete?view=graph-rest-1.0
+func (g Graph) DeleteExtension(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+	e := ctx.Value(extensionKey).(*extension)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if err := removeExtension(ctx, client, ref, e.name); err != nil {
+		g.logger.Error().Err(err).Msgf("error deleting extension %s of %s", e.name, ref)
+		renderError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
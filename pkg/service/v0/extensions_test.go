@@ -0,0 +1,159 @@
+package svc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+)
+
+func TestDecodeExtension(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		param   string
+		want    string
+		props   map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:  "name from body",
+			body:  `{"@odata.type":"#microsoft.graph.openTypeExtension","extensionName":"com.example.records","id":"x","owner":"alice"}`,
+			want:  "com.example.records",
+			props: map[string]interface{}{"owner": "alice"},
+		},
+		{
+			name:  "name from url",
+			body:  `{"extensionName":"org.other","count":1}`,
+			param: "com.example.records",
+			want:  "com.example.records",
+			props: map[string]interface{}{"count": float64(1)},
+		},
+		{
+			name:    "missing name",
+			body:    `{"owner":"alice"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid characters",
+			body:    `{"extensionName":"com/example"}`,
+			wantErr: true,
+		},
+		{
+			name:    "too long",
+			body:    `{"extensionName":"` + strings.Repeat("a", 101) + `"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			body:    `{"extensionName":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/extensions", strings.NewReader(tt.body))
+			e, err := decodeExtension(r, tt.param)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeExtension() = %q, want an error", e.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeExtension() error = %v", err)
+			}
+			if e.name != tt.want {
+				t.Errorf("decodeExtension() name = %q, want %q", e.name, tt.want)
+			}
+			if !reflect.DeepEqual(e.props, tt.props) {
+				t.Errorf("decodeExtension() props = %v, want %v", e.props, tt.props)
+			}
+		})
+	}
+}
+
+func TestExtensionMerge(t *testing.T) {
+	e := &extension{name: "com.example.records", props: map[string]interface{}{
+		"owner": "alice",
+		"count": float64(1),
+		"tag":   "old",
+	}}
+	r := httptest.NewRequest(http.MethodPatch, "/extensions/com.example.records", strings.NewReader(`{"owner":null,"tag":"new","color":"red","missing":null}`))
+	update, err := decodeExtension(r, e.name)
+	if err != nil {
+		t.Fatalf("decodeExtension() error = %v", err)
+	}
+
+	e.merge(update)
+	want := map[string]interface{}{
+		"count": float64(1),
+		"tag":   "new",
+		"color": "red",
+	}
+	if !reflect.DeepEqual(e.props, want) {
+		t.Errorf("merge() props = %v, want %v", e.props, want)
+	}
+}
+
+// metadataGateway fakes the arbitrary metadata of a single resource.
+type metadataGateway struct {
+	gateway.GatewayAPIClient
+	metadata map[string]string
+}
+
+func (c *metadataGateway) Stat(ctx context.Context, in *storageprovider.StatRequest, opts ...grpc.CallOption) (*storageprovider.StatResponse, error) {
+	md := map[string]string{}
+	for _, k := range in.ArbitraryMetadataKeys {
+		if v, ok := c.metadata[k]; ok {
+			md[k] = v
+		}
+	}
+	return &storageprovider.StatResponse{
+		Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+		Info: &storageprovider.ResourceInfo{
+			ArbitraryMetadata: &storageprovider.ArbitraryMetadata{Metadata: md},
+		},
+	}, nil
+}
+
+func (c *metadataGateway) SetArbitraryMetadata(ctx context.Context, in *storageprovider.SetArbitraryMetadataRequest, opts ...grpc.CallOption) (*storageprovider.SetArbitraryMetadataResponse, error) {
+	for k, v := range in.ArbitraryMetadata.Metadata {
+		c.metadata[k] = v
+	}
+	return &storageprovider.SetArbitraryMetadataResponse{
+		Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+	}, nil
+}
+
+func TestWriteExtensionMergesIndex(t *testing.T) {
+	client := &metadataGateway{metadata: map[string]string{}}
+	ref := &storageprovider.Reference{Spec: &storageprovider.Reference_Path{Path: "/file"}}
+	ctx := context.Background()
+
+	for _, name := range []string{"b", "a"} {
+		e := &extension{name: name, props: map[string]interface{}{}}
+		if err := writeExtension(ctx, client, ref, e, true); err != nil {
+			t.Fatalf("writeExtension(%s) error = %v", name, err)
+		}
+	}
+	if err := writeExtension(ctx, client, ref, &extension{name: "a", props: map[string]interface{}{}}, true); err != errExtensionExists {
+		t.Errorf("writeExtension() of an existing extension error = %v, want %v", err, errExtensionExists)
+	}
+
+	names, err := readExtensionNames(ctx, client, ref)
+	if err != nil {
+		t.Fatalf("readExtensionNames() error = %v", err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("readExtensionNames() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,347 @@
+package svc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// favoriteMetadataKey is the arbitrary metadata key the ownCloud clients
+// use for favorites. The storage drivers keep it per user.
+const favoriteMetadataKey = "http://owncloud.org/ns/favorite"
+
+// followingPageSize defines how many followed items are returned per page.
+const followingPageSize = 100
+
+// followingMetadataKeys are the arbitrary metadata keys requested for
+// followed items.
+var followingMetadataKeys = append([]string{favoriteMetadataKey}, driveItemMetadataKeys...)
+
+// followingStorePrefix returns the prefix of the store keys of the items a
+// user follows. Storages can not be searched for favorites, so the store
+// indexes the followed items for listing them.
+func followingStorePrefix(u *userv1beta1.User) string {
+	return "following/" + base64.RawURLEncoding.EncodeToString([]byte(u.Id.Idp+":"+u.Id.OpaqueId)) + "/"
+}
+
+// followEntry is the value of a followed item in the store. If the storage
+// supports the favorite flag, the flag is the record of the follow and
+// removing it with another client unfollows the item. Otherwise the store
+// is the record.
+type followEntry struct {
+	DriveID  string `json:"drive_id"`
+	Favorite bool   `json:"favorite"`
+}
+
+// setFavorite marks ref as favorite of the user or removes the mark. It
+// returns false if the storage driver does not support arbitrary metadata.
+func setFavorite(ctx context.Context, client gateway.GatewayAPIClient, ref *storageprovider.Reference, favorite bool) (bool, error) {
+	var status *cs3rpc.Status
+	if favorite {
+		res, err := client.SetArbitraryMetadata(ctx, &storageprovider.SetArbitraryMetadataRequest{
+			Ref: ref,
+			ArbitraryMetadata: &storageprovider.ArbitraryMetadata{
+				Metadata: map[string]string{favoriteMetadataKey: "1"},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		status = res.Status
+	} else {
+		res, err := client.UnsetArbitraryMetadata(ctx, &storageprovider.UnsetArbitraryMetadataRequest{
+			Ref:                   ref,
+			ArbitraryMetadataKeys: []string{favoriteMetadataKey},
+		})
+		if err != nil {
+			return false, err
+		}
+		status = res.Status
+	}
+
+	switch status.Code {
+	case cs3rpc.Code_CODE_OK:
+		return true, nil
+	case cs3rpc.Code_CODE_UNIMPLEMENTED:
+		return false, nil
+	default:
+		return false, &statusError{status: status}
+	}
+}
+
+// isFavorite tells whether info carries the favorite flag.
+func isFavorite(info *storageprovider.ResourceInfo) bool {
+	v := info.ArbitraryMetadata.GetMetadata()[favoriteMetadataKey]
+	return v != "" && v != "0"
+}
+
+// followTarget stats the item resolved by DriveItemCtx and returns it with
+// the user of the request. It renders the error response if that fails.
+func (g Graph) followTarget(w http.ResponseWriter, r *http.Request, client gateway.GatewayAPIClient) (*storageprovider.ResourceInfo, *userv1beta1.User, bool) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	u := revaUser(ctx)
+	if u == nil || u.Id == nil {
+		errorcode.Unauthenticated.Render(w, r, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return nil, nil, false
+	}
+	return statRes.Info, u, true
+}
+
+// FollowDriveItem adds the item resolved by DriveItemCtx to the items the
+// user follows, see https://docs.microsoft.com/en-us/graph/api/driveitem-follow?view=graph-rest-1.0
+func (g Graph) FollowDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	info, u, ok := g.followTarget(w, r, client)
+	if !ok {
+		return
+	}
+
+	ref := &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Id{Id: info.Id},
+	}
+	favorite, err := setFavorite(ctx, client, ref, true)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error setting favorite %s", info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	key := followingStorePrefix(u) + wrapResourceID(info.Id)
+	b, err := json.Marshal(&followEntry{DriveID: wrapResourceID(root.Id), Favorite: favorite})
+	if err == nil {
+		err = g.store.Write(key, b)
+	}
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error writing %s", key)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, item)
+}
+
+// UnfollowDriveItem removes the item resolved by DriveItemCtx from the items
+// the user follows, see https://docs.microsoft.com/en-us/graph/api/driveitem-unfollow?view=graph-rest-1.0
+func (g Graph) UnfollowDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	info, u, ok := g.followTarget(w, r, client)
+	if !ok {
+		return
+	}
+
+	ref := &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Id{Id: info.Id},
+	}
+	if _, err := setFavorite(ctx, client, ref, false); err != nil {
+		g.logger.Error().Err(err).Msgf("error unsetting favorite %s", info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	key := followingStorePrefix(u) + wrapResourceID(info.Id)
+	if err := g.store.Delete(key); err != nil {
+		g.logger.Error().Err(err).Msgf("error deleting %s", key)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFollowing lists the items the user follows, each with the drive it was
+// followed in. Items that are gone, no longer accessible or unfollowed by
+// removing the favorite flag are dropped. The skip token is the id of the
+// last item of the previous page,
+// see https://docs.microsoft.com/en-us/graph/api/drive-list-following?view=graph-rest-1.0
+func (g Graph) GetFollowing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	top, ok := parseTop(query, followingPageSize)
+	if !ok {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	u := revaUser(ctx)
+	if u == nil || u.Id == nil {
+		errorcode.Unauthenticated.Render(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	prefix := followingStorePrefix(u)
+	keys, err := g.store.List(prefix)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing %s", prefix)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(keys)
+	if after := query.Get("$skipToken"); after != "" {
+		keys = keys[sort.Search(len(keys), func(i int) bool { return keys[i] > prefix+after }):]
+	}
+
+	resp := &listResponse{}
+	items := []*msgraph.DriveItem{}
+	roots := map[string]*storageprovider.ResourceInfo{}
+	for i, key := range keys {
+		if len(items) == top {
+			query.Set("$skipToken", strings.TrimPrefix(keys[i-1], prefix))
+			resp.NextLink = g.linkURL(r, query)
+			break
+		}
+		info, root, err := g.followedItem(ctx, client, key, roots)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error reading followed item %s", key)
+			renderError(w, r, err)
+			return
+		}
+		if info == nil {
+			continue
+		}
+		item, err := cs3ResourceToDriveItem(info, root)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		items = append(items, item)
+	}
+	resp.Value = items
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
+
+// followedItem stats the followed item of a store key and the root of the
+// drive it was followed in, roots caches the roots per drive id. It
+// returns a nil info for items that are no longer followed and removes
+// their key.
+func (g Graph) followedItem(ctx context.Context, client gateway.GatewayAPIClient, key string, roots map[string]*storageprovider.ResourceInfo) (*storageprovider.ResourceInfo, *storageprovider.ResourceInfo, error) {
+	b, err := g.store.Read(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &followEntry{}
+	if err := json.Unmarshal(b, entry); err != nil {
+		return nil, nil, err
+	}
+
+	info, err := g.statFollowed(ctx, client, key[strings.LastIndex(key, "/")+1:], followingMetadataKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info != nil && entry.Favorite && !isFavorite(info) {
+		info = nil
+	}
+	if info == nil {
+		if err := g.store.Delete(key); err != nil {
+			g.logger.Error().Err(err).Msgf("error deleting %s", key)
+		}
+		return nil, nil, nil
+	}
+
+	root, ok := roots[entry.DriveID]
+	if !ok {
+		if root, err = g.statFollowed(ctx, client, entry.DriveID, nil); err != nil {
+			return nil, nil, err
+		}
+		roots[entry.DriveID] = root
+	}
+	if root == nil {
+		// the drive is no longer accessible, the item can not be addressed in it
+		return nil, nil, nil
+	}
+	return info, root, nil
+}
+
+// statFollowed stats the resource with the wrapped id. It returns a nil
+// info for resources that are gone or no longer accessible.
+func (g Graph) statFollowed(ctx context.Context, client gateway.GatewayAPIClient, id string, keys []string) (*storageprovider.ResourceInfo, error) {
+	rid := unwrapResourceID(id)
+	if rid == nil {
+		return nil, nil
+	}
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Id{Id: rid},
+		},
+		ArbitraryMetadataKeys: keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch res.Status.Code {
+	case cs3rpc.Code_CODE_OK:
+		return res.Info, nil
+	case cs3rpc.Code_CODE_NOT_FOUND, cs3rpc.Code_CODE_PERMISSION_DENIED:
+		return nil, nil
+	default:
+		return nil, &statusError{status: res.Status}
+	}
+}
@@ -0,0 +1,106 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	"github.com/owncloud/ocis-pkg/v2/log"
+	"google.golang.org/grpc"
+)
+
+// followGateway fakes the stats of followed items. Resources are stated by
+// opaque id, unknown ids are not found.
+type followGateway struct {
+	gateway.GatewayAPIClient
+	infos map[string]*storageprovider.ResourceInfo
+	stats int
+}
+
+func (c *followGateway) Stat(ctx context.Context, in *storageprovider.StatRequest, opts ...grpc.CallOption) (*storageprovider.StatResponse, error) {
+	c.stats++
+	info, ok := c.infos[in.Ref.GetId().GetOpaqueId()]
+	if !ok {
+		return &storageprovider.StatResponse{Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_NOT_FOUND}}, nil
+	}
+	return &storageprovider.StatResponse{Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK}, Info: info}, nil
+}
+
+func TestFollowedItem(t *testing.T) {
+	resource := func(id string, favorite string) *storageprovider.ResourceInfo {
+		info := &storageprovider.ResourceInfo{
+			Id:   &storageprovider.ResourceId{StorageId: "storage", OpaqueId: id},
+			Path: "/" + id,
+		}
+		if favorite != "" {
+			info.ArbitraryMetadata = &storageprovider.ArbitraryMetadata{
+				Metadata: map[string]string{favoriteMetadataKey: favorite},
+			}
+		}
+		return info
+	}
+	client := &followGateway{infos: map[string]*storageprovider.ResourceInfo{
+		"drive":     resource("drive", ""),
+		"favorite":  resource("favorite", "1"),
+		"unfavored": resource("unfavored", "0"),
+		"storeonly": resource("storeonly", ""),
+		"lostdrive": resource("lostdrive", "1"),
+		"unflagged": resource("unflagged", ""),
+	}}
+	drive := wrapResourceID(client.infos["drive"].Id)
+
+	tests := []struct {
+		id       string
+		entry    followEntry
+		followed bool
+	}{
+		{"favorite", followEntry{DriveID: drive, Favorite: true}, true},
+		{"unfavored", followEntry{DriveID: drive, Favorite: true}, false},
+		{"unflagged", followEntry{DriveID: drive, Favorite: true}, false},
+		{"storeonly", followEntry{DriveID: drive}, true},
+		{"gone", followEntry{DriveID: drive}, false},
+		{"lostdrive", followEntry{DriveID: wrapResourceID(&storageprovider.ResourceId{StorageId: "storage", OpaqueId: "gone"}), Favorite: true}, false},
+	}
+
+	logger := log.NewLogger(log.Level("fatal"))
+	g := Graph{logger: &logger, store: store.NewMemory()}
+	roots := map[string]*storageprovider.ResourceInfo{}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			key := "following/user/" + wrapResourceID(&storageprovider.ResourceId{StorageId: "storage", OpaqueId: tt.id})
+			b, _ := json.Marshal(&tt.entry)
+			if err := g.store.Write(key, b); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			info, root, err := g.followedItem(context.Background(), client, key, roots)
+			if err != nil {
+				t.Fatalf("followedItem() error = %v", err)
+			}
+			if followed := info != nil; followed != tt.followed {
+				t.Fatalf("followedItem() followed = %v, want %v", followed, tt.followed)
+			}
+			if tt.followed && root.Path != "/drive" {
+				t.Errorf("followedItem() root = %s, want /drive", root.Path)
+			}
+
+			// the key of items that are gone or unfollowed is removed,
+			// items of drives that are not accessible are kept
+			_, err = g.store.Read(key)
+			wantRemoved := !tt.followed && tt.id != "lostdrive"
+			if removed := errors.Is(err, store.ErrNotFound); removed != wantRemoved {
+				t.Errorf("Read() error = %v, want key removed %v", err, wantRemoved)
+			}
+		})
+	}
+
+	// the root of the drive is stated once
+	if client.stats != len(tests)+2 {
+		t.Errorf("stats = %d, want %d", client.stats, len(tests)+2)
+	}
+}
@@ -2,11 +2,17 @@ package svc
 
 import (
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 
 	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
 	"github.com/go-chi/chi"
 	"github.com/owncloud/ocis-graph/pkg/config"
 	"github.com/owncloud/ocis-graph/pkg/cs3"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/store"
 	"github.com/owncloud/ocis-pkg/v2/log"
 )
 
@@ -15,6 +21,17 @@ type Graph struct {
 	config *config.Config
 	mux    *chi.Mux
 	logger *log.Logger
+	store  store.Store
+	jobs   *jobs.Runner
+
+	importClient *http.Client
+	dataClient   *http.Client
+	uploadLocks  *keyLocks
+	deltaLocks   *keyLocks
+
+	// thumbnailSlots limits the thumbnails generated at the same time,
+	// decoding images takes a lot of memory.
+	thumbnailSlots chan struct{}
 }
 
 // ServeHTTP implements the Service interface.
@@ -27,13 +44,120 @@ func (g Graph) GetClient() (gateway.GatewayAPIClient, error) {
 	return cs3.GetGatewayServiceClient(g.config.Reva.Address)
 }
 
+// absoluteURL returns the absolute url of p below the graph api root.
+func (g Graph) absoluteURL(r *http.Request, p string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + path.Join(g.config.HTTP.Root, "v1.0", p)
+}
+
+// requestPath returns the escaped path of the request below the graph api root.
+func (g Graph) requestPath(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.EscapedPath(), path.Join(g.config.HTTP.Root, "v1.0"))
+}
+
+// linkURL returns the absolute url of the request with the given query,
+// e.g. for delta and next links.
+func (g Graph) linkURL(r *http.Request, query url.Values) string {
+	return g.absoluteURL(r, g.requestPath(r)) + "?" + query.Encode()
+}
+
+// routingPath returns the path chi routes on, the raw path if the request
+// has one and the decoded path otherwise.
+func routingPath(r *http.Request) string {
+	if r.URL.RawPath != "" {
+		return r.URL.RawPath
+	}
+	return r.URL.Path
+}
+
+// escapedSuffix returns the end of the escaped request path that matches
+// s, a suffix of the routing path, e.g. a route path or a wildcard
+// parameter. Both paths have the same slashes, decoded slashes make chi
+// route the raw path. Trailing slashes are stripped like StripSlashes does.
+func escapedSuffix(r *http.Request, s string) string {
+	escaped := r.URL.EscapedPath()
+	if len(escaped) > 1 && !strings.HasSuffix(s, "/") {
+		escaped = strings.TrimSuffix(escaped, "/")
+	}
+
+	n := strings.Count(s, "/")
+	if !strings.HasPrefix(s, "/") {
+		n++
+	}
+	i := len(escaped)
+	for ; n > 0; n-- {
+		if i = strings.LastIndex(escaped[:i], "/"); i < 0 {
+			return s
+		}
+	}
+	if !strings.HasPrefix(s, "/") {
+		i++
+	}
+	return escaped[i:]
+}
+
 // The key type is unexported to prevent collisions with context keys defined in
 // other packages.
 type key int
 
 const userIDKey key = 0
 const groupIDKey key = 1
+const driveItemKey key = 2
+const driveRootKey key = 3
+const driveItemPathKey key = 4
+const uploadSessionKey key = 5
+const recycleItemKey key = 6
+const permissionKey key = 7
+const receivedShareKey key = 8
+const versionKey key = 9
+const specialFolderKey key = 10
+const revaUserKey key = 11
+const extensionKey key = 12
+
+// maxPageSize caps the $top query parameter of paged lists.
+const maxPageSize = 200
+
+// parsePage parses the $top and $skipToken query parameters of a paged
+// list, the skip token is the offset of the page. top defaults to def and
+// is capped at maxPageSize.
+func parsePage(query url.Values, def int) (top, skip int, ok bool) {
+	if top, ok = parseTop(query, def); !ok {
+		return 0, 0, false
+	}
+	if s := query.Get("$skipToken"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		skip = n
+	}
+	return top, skip, true
+}
+
+// parseTop parses the $top query parameter of a paged list. It defaults to
+// def and is capped at maxPageSize.
+func parseTop(query url.Values, def int) (int, bool) {
+	top := def
+	if s := query.Get("$top"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		top = n
+	}
+	if top > maxPageSize {
+		top = maxPageSize
+	}
+	return top, true
+}
 
 type listResponse struct {
-	Value interface{} `json:"value,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	NextLink string      `json:"@odata.nextLink,omitempty"`
 }
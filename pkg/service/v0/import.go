@@ -0,0 +1,275 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/cs3org/reva/pkg/token"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+)
+
+// errImportNotAllowed is returned when an import would reach a host that
+// is not allowed or an address in a private network.
+var errImportNotAllowed = errors.New("import source not allowed")
+
+// errImportTooLarge is returned when an import source exceeds the maximum
+// size of imported files.
+var errImportTooLarge = errors.New("import source too large")
+
+// blockedNetworks can not be reached by imports, so users can not make the
+// service fetch resources of the internal network, e.g. the metadata
+// endpoint of a cloud provider at 169.254.169.254.
+var blockedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"64:ff9b::/96",
+		"64:ff9b:1::/48",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP checks that ip is not part of the blocked networks.
+func publicIP(ip net.IP) bool {
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// importMaxRedirects limits the redirects followed when downloading a source.
+const importMaxRedirects = 10
+
+// newImportClient returns the http client downloading import sources. It
+// only connects to addresses accepted by allowIP, which is checked after
+// name resolution, so hosts can not point to blocked addresses via DNS.
+// Redirects are only followed to allowed hosts. If allowedHosts is empty
+// all hosts are allowed. No proxy is used, it would hide the address.
+func newImportClient(allowedHosts []string, allowIP func(net.IP) bool) *http.Client {
+	hosts := map[string]bool{}
+	for _, h := range allowedHosts {
+		hosts[strings.ToLower(h)] = true
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowIP(ip) {
+				return errImportNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: time.Hour,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= importMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", importMaxRedirects)
+			}
+			if !sourceAllowed(req.URL, hosts) {
+				return errImportNotAllowed
+			}
+			return nil
+		},
+	}
+}
+
+// sourceAllowed checks that u is an http or https url of an allowed host.
+func sourceAllowed(u *url.URL, hosts map[string]bool) bool {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return false
+	}
+	return len(hosts) == 0 || hosts[strings.ToLower(u.Hostname())]
+}
+
+// parseSourceURL validates the url of a remote file to import. Only http
+// and https sources of the allowed hosts are supported.
+func (g Graph) parseSourceURL(s string) (*url.URL, bool) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, false
+	}
+	hosts := map[string]bool{}
+	for _, h := range g.config.Import.AllowedHosts {
+		hosts[strings.ToLower(h)] = true
+	}
+	return u, sourceAllowed(u, hosts)
+}
+
+// importFile downloads the remote file at source with sourceClient and
+// uploads it to the path fn with dataClient. Downloads are cut off when
+// they exceed the remaining quota or maxSize, if it is not 0. Sources that
+// do not send a Content-Length are spooled to a temporary file first,
+// uploads need to know their length up front.
+func importFile(ctx context.Context, sourceClient, dataClient *http.Client, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo, source *url.URL, fn string, replaced uint64, maxSize int64, progress jobs.Progress) error {
+	remaining, err := remainingQuota(ctx, client, root, replaced)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := sourceClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %d downloading %s", res.StatusCode, source)
+	}
+
+	// checkLength returns the error of a source of n bytes
+	checkLength := func(n int64) error {
+		switch {
+		case remaining >= 0 && n > remaining:
+			return errQuotaExceeded
+		case maxSize > 0 && n > maxSize:
+			return errImportTooLarge
+		}
+		return nil
+	}
+
+	length := res.ContentLength
+	if err := checkLength(length); err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if length >= 0 {
+		body = io.LimitReader(res.Body, length)
+	} else {
+		f, err := ioutil.TempFile("", "ocis-graph-import")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		// one more byte than allowed tells if the source is too large
+		limit := int64(-1)
+		if remaining >= 0 {
+			limit = remaining + 1
+		}
+		if maxSize > 0 && (limit < 0 || maxSize+1 < limit) {
+			limit = maxSize + 1
+		}
+		var src io.Reader = res.Body
+		if limit >= 0 {
+			src = io.LimitReader(res.Body, limit)
+		}
+		if length, err = io.Copy(f, src); err != nil {
+			return err
+		}
+		if err := checkLength(length); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = f
+	}
+
+	ref := &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: fn},
+	}
+	if err := checkRefLock(ctx, client, ref); err != nil {
+		return err
+	}
+	c := &copier{
+		httpClient: dataClient,
+		client:     client,
+		total:      length,
+		progress:   progress,
+	}
+	return uploadFile(ctx, dataClient, client, ref, length, &progressReader{r: body, c: c})
+}
+
+// importDriveItem imports the remote file at source to the path fn in the
+// background. The response carries the url of a monitor that reports the
+// progress, see https://docs.microsoft.com/en-us/graph/api/driveitem-upload-url?view=graph-rest-1.0
+func (g Graph) importDriveItem(w http.ResponseWriter, r *http.Request, client gateway.GatewayAPIClient, source *url.URL, fn string, replaced uint64) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	// the job outlives the request, so it needs its own copy of the reva token
+	revaToken, _ := token.ContextGetToken(ctx)
+	jobID, err := g.jobs.Submit("itemImport", func(ctx context.Context, progress jobs.Progress) (string, error) {
+		ctx = withRevaToken(ctx, revaToken)
+		if err := importFile(ctx, g.importClient, g.dataClient, client, root, source, fn, replaced, g.config.Import.MaxSize, progress); err != nil {
+			return "", err
+		}
+
+		res, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: fn},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			return "", &statusError{status: res.Status}
+		}
+		return wrapResourceID(res.Info.Id), nil
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error submitting import of %s to %s", source, fn)
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Location", g.absoluteURL(r, "/monitor/"+jobID))
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,258 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+)
+
+// importGateway fakes the gateway calls of an import. Uploads are sent to
+// the data gateway at endpoint. With a lock the target is checked out by
+// another user.
+type importGateway struct {
+	gateway.GatewayAPIClient
+	total, used uint64
+	endpoint    string
+	lock        string
+}
+
+func (c *importGateway) Stat(ctx context.Context, in *storageprovider.StatRequest, opts ...grpc.CallOption) (*storageprovider.StatResponse, error) {
+	if c.lock == "" {
+		return &storageprovider.StatResponse{Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_NOT_FOUND}}, nil
+	}
+	return &storageprovider.StatResponse{
+		Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+		Info: &storageprovider.ResourceInfo{
+			Path: in.Ref.GetPath(),
+			ArbitraryMetadata: &storageprovider.ArbitraryMetadata{
+				Metadata: map[string]string{lockMetadataKey: c.lock},
+			},
+		},
+	}, nil
+}
+
+func (c *importGateway) GetQuota(ctx context.Context, in *gateway.GetQuotaRequest, opts ...grpc.CallOption) (*storageprovider.GetQuotaResponse, error) {
+	return &storageprovider.GetQuotaResponse{
+		Status:     &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+		TotalBytes: c.total,
+		UsedBytes:  c.used,
+	}, nil
+}
+
+func (c *importGateway) InitiateFileUpload(ctx context.Context, in *storageprovider.InitiateFileUploadRequest, opts ...grpc.CallOption) (*gateway.InitiateFileUploadResponse, error) {
+	return &gateway.InitiateFileUploadResponse{
+		Status:         &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+		UploadEndpoint: c.endpoint,
+		Token:          "token",
+	}, nil
+}
+
+// allowAllIPs lets the import client reach the test servers on loopback.
+func allowAllIPs(net.IP) bool {
+	return true
+}
+
+func TestImportFile(t *testing.T) {
+	content := "imported content"
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		total   uint64
+		used    uint64
+		lock    string
+		maxSize int64
+		want    string
+		err     error
+	}{
+		{
+			name: "with content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name: "without content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name: "fits into quota",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			total: 100,
+			used:  100 - uint64(len(content)),
+			want:  content,
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			err: errors.New("unexpected response"),
+		},
+		{
+			name: "exceeds quota",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			total: 100,
+			used:  95,
+			err:   errQuotaExceeded,
+		},
+		{
+			name: "exceeds quota without content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			total: 100,
+			used:  95,
+			err:   errQuotaExceeded,
+		},
+		{
+			name: "exceeds maximum size",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			maxSize: 5,
+			err:     errImportTooLarge,
+		},
+		{
+			name: "exceeds maximum size without content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			total:   100,
+			maxSize: 5,
+			err:     errImportTooLarge,
+		},
+		{
+			name: "within maximum size without content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			maxSize: int64(len(content)),
+			want:    content,
+		},
+		{
+			name: "checked out by another user",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content))
+			},
+			lock: `{"idp":"idp","user_id":"einstein"}`,
+			err:  errLocked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := httptest.NewServer(tt.handler)
+			defer source.Close()
+
+			var uploaded *string
+			data := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				s := string(b)
+				uploaded = &s
+			}))
+			defer data.Close()
+
+			client := &importGateway{total: tt.total, used: tt.used, endpoint: data.URL, lock: tt.lock}
+			u, _ := url.Parse(source.URL)
+			err := importFile(context.Background(), newImportClient(nil, allowAllIPs), newDataClient(), client, &storageprovider.ResourceInfo{Path: "/"}, u, "/file.txt", 0, tt.maxSize, func(float64) {})
+
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("importFile() error = %v", err)
+			case (tt.err == errQuotaExceeded || tt.err == errImportTooLarge || tt.err == errLocked) && err != tt.err:
+				t.Fatalf("importFile() error = %v, want %v", err, tt.err)
+			case tt.err != nil && (err == nil || !strings.Contains(err.Error(), tt.err.Error())):
+				t.Fatalf("importFile() error = %v, want %v", err, tt.err)
+			}
+
+			if tt.err != nil {
+				if uploaded != nil {
+					t.Errorf("failed import uploaded %q", *uploaded)
+				}
+				return
+			}
+			if uploaded == nil || *uploaded != tt.want {
+				t.Errorf("uploaded %v, want %q", uploaded, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportClientPrivateAddress(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer source.Close()
+
+	_, err := newImportClient(nil, publicIP).Get(source.URL)
+	if !errors.Is(err, errImportNotAllowed) {
+		t.Fatalf("Get() error = %v, want %v", err, errImportNotAllowed)
+	}
+}
+
+func TestImportClientRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer source.Close()
+
+	_, err := newImportClient([]string{"127.0.0.1"}, allowAllIPs).Get(source.URL)
+	if !errors.Is(err, errImportNotAllowed) {
+		t.Fatalf("Get() error = %v, want %v", err, errImportNotAllowed)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"::", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"64:ff9b::7f00:1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
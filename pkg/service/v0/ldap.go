@@ -7,17 +7,28 @@ import (
 	msgraph "github.com/yaegashi/msgraph.go/v1.0"
 )
 
+// errEntryNotFound is returned when a search has no result, so callers can
+// tell a missing entry from an unavailable directory.
+var errEntryNotFound = errors.New("resource not found")
+
 func (g Graph) ldapGetSingleEntry(baseDn string, filter string) (*ldap.Entry, error) {
 	conn, err := g.initLdap()
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+	return g.ldapSearchSingleEntry(conn, baseDn, filter)
+}
+
+// ldapSearchSingleEntry is ldapGetSingleEntry on an open connection, so
+// the lookups of a request can share it.
+func (g Graph) ldapSearchSingleEntry(conn *ldap.Conn, baseDn string, filter string) (*ldap.Entry, error) {
 	result, err := g.ldapSearch(conn, filter, baseDn)
 	if err != nil {
 		return nil, err
 	}
 	if len(result.Entries) == 0 {
-		return nil, errors.New("resource not found")
+		return nil, errEntryNotFound
 	}
 	return result.Entries[0], nil
 }
@@ -31,11 +42,24 @@ func (g Graph) initLdap() (*ldap.Conn, error) {
 	}
 
 	if err := con.Bind(g.config.Ldap.UserName, g.config.Ldap.Password); err != nil {
+		con.Close()
 		return nil, err
 	}
 	return con, nil
 }
 
+// openLdap opens the ldap connection for the identity lookups of a request.
+// Lookups fall back to the CS3 ids without a connection, so a failure is
+// only logged and the connection is nil. The returned func closes it.
+func (g Graph) openLdap() (*ldap.Conn, func()) {
+	conn, err := g.initLdap()
+	if err != nil {
+		g.logger.Error().Err(err).Msg("Failed to initialize ldap")
+		return nil, func() {}
+	}
+	return conn, func() { conn.Close() }
+}
+
 func (g Graph) ldapSearch(con *ldap.Conn, filter string, baseDN string) (*ldap.SearchResult, error) {
 	search := ldap.NewSearchRequest(
 		baseDN,
@@ -0,0 +1,196 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+const (
+	linkTypeView   = "view"
+	linkTypeEdit   = "edit"
+	linkTypeUpload = "upload"
+)
+
+// linkTypePermissions maps a sharing link type to CS3 permissions.
+func linkTypePermissions(linkType string) (*storageprovider.ResourcePermissions, error) {
+	switch linkType {
+	case linkTypeView:
+		return rolePermissions(roleRead)
+	case linkTypeEdit:
+		return rolePermissions(roleWrite)
+	case linkTypeUpload:
+		// a drop folder, the content can not be listed or downloaded
+		return &storageprovider.ResourcePermissions{
+			CreateContainer:    true,
+			InitiateFileUpload: true,
+			Stat:               true,
+		}, nil
+	default:
+		return nil, errUnknownRole
+	}
+}
+
+// permissionsLinkType maps CS3 permissions to the sharing link type.
+func permissionsLinkType(p *storageprovider.ResourcePermissions) string {
+	switch {
+	case p == nil:
+		return linkTypeView
+	case p.InitiateFileUpload && !p.InitiateFileDownload:
+		return linkTypeUpload
+	case p.InitiateFileUpload:
+		return linkTypeEdit
+	default:
+		return linkTypeView
+	}
+}
+
+// publicLinkURL returns the url of the web UI for a public link token.
+func (g Graph) publicLinkURL(token string) string {
+	return strings.TrimRight(g.config.Sharing.PublicURL, "/") + "/#/s/" + token
+}
+
+// linkPermission is a permission with a sharing link. The msgraph models
+// predate the password and expiration properties of links, so they are
+// added here.
+type linkPermission struct {
+	*msgraph.Permission
+	HasPassword        *bool      `json:"hasPassword,omitempty"`
+	ExpirationDateTime *time.Time `json:"expirationDateTime,omitempty"`
+}
+
+// cs3PublicShareToPermission converts a CS3 public share into a permission
+// with a sharing link.
+func (g Graph) cs3PublicShareToPermission(share *link.PublicShare) *linkPermission {
+	id := linkPermissionPrefix + share.Id.OpaqueId
+	var permissions *storageprovider.ResourcePermissions
+	if share.Permissions != nil {
+		permissions = share.Permissions.Permissions
+	}
+	linkType := permissionsLinkType(permissions)
+	scope := "anonymous"
+	webURL := g.publicLinkURL(share.Token)
+	hasPassword := share.PasswordProtected
+
+	permission := &linkPermission{
+		Permission: &msgraph.Permission{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+			Link: &msgraph.SharingLink{
+				Type:   &linkType,
+				Scope:  &scope,
+				WebURL: &webURL,
+			},
+			Roles: []string{permissionsRole(permissions)},
+		},
+		HasPassword: &hasPassword,
+	}
+	if share.Expiration != nil {
+		expiration := time.Unix(int64(share.Expiration.Seconds), int64(share.Expiration.Nanos))
+		permission.ExpirationDateTime = &expiration
+	}
+	return permission
+}
+
+// CreateLink creates an anonymous sharing link for the item resolved by
+// DriveItemCtx, see https://docs.microsoft.com/en-us/graph/api/driveitem-createlink?view=graph-rest-1.0
+func (g Graph) CreateLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := struct {
+		Type               string     `json:"type"`
+		Scope              string     `json:"scope"`
+		Password           string     `json:"password"`
+		ExpirationDateTime *time.Time `json:"expirationDateTime"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode create link request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.Scope != "" && req.Scope != "anonymous" {
+		errorcode.NotSupported.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	permissions, err := linkTypePermissions(req.Type)
+	if err != nil {
+		g.logger.Debug().Str("type", req.Type).Msg("unknown link type")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.ExpirationDateTime != nil && req.ExpirationDateTime.Before(time.Now()) {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Path == root.Path {
+		errorcode.NotAllowed.Render(w, r, http.StatusForbidden)
+		return
+	}
+	if req.Type == linkTypeUpload && statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		// only folders can receive uploads
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	grant := &link.Grant{
+		Permissions: &link.PublicSharePermissions{
+			Permissions: permissions,
+		},
+		Password: req.Password,
+	}
+	if req.ExpirationDateTime != nil {
+		grant.Expiration = &typespb.Timestamp{
+			Seconds: uint64(req.ExpirationDateTime.Unix()),
+			Nanos:   uint32(req.ExpirationDateTime.Nanosecond()),
+		}
+	}
+
+	res, err := client.CreatePublicShare(ctx, &link.CreatePublicShareRequest{
+		ResourceInfo: statRes.Info,
+		Grant:        grant,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending create public share grpc request %s", statRes.Info.Path)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc create public share %s", statRes.Info.Path)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, g.cs3PublicShareToPermission(res.Share))
+}
@@ -0,0 +1,48 @@
+package svc
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// monitorResponse is the state of a job with the reason it failed.
+type monitorResponse struct {
+	jobs.Job
+	Error *msgraph.ErrorObject `json:"error,omitempty"`
+}
+
+// GetMonitor reports the state of an asynchronous operation. Like the
+// upload session url the monitor url is pre-authenticated by its random id,
+// see https://docs.microsoft.com/en-us/graph/long-running-actions-overview
+func (g Graph) GetMonitor(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	job, ok := g.jobs.Get(jobID)
+	if !ok {
+		g.logger.Info().Msgf("Failed to read job %s", jobID)
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return
+	}
+
+	resp := &monitorResponse{Job: job}
+	if job.Err != nil {
+		code, _ := errorCode(job.Err)
+		resp.Error = &msgraph.ErrorObject{
+			Code:    code.String(),
+			Message: job.Err.Error(),
+		}
+	}
+
+	switch job.Status {
+	case jobs.StatusNotStarted, jobs.StatusInProgress:
+		render.Status(r, http.StatusAccepted)
+	default:
+		render.Status(r, http.StatusOK)
+	}
+	render.JSON(w, r, resp)
+}
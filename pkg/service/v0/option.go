@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/owncloud/ocis-graph/pkg/config"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/store"
 	"github.com/owncloud/ocis-pkg/v2/log"
 )
 
@@ -14,6 +16,8 @@ type Option func(o *Options)
 type Options struct {
 	Logger     log.Logger
 	Config     *config.Config
+	Store      store.Store
+	Jobs       *jobs.Runner
 	Middleware []func(http.Handler) http.Handler
 }
 
@@ -42,6 +46,20 @@ func Config(val *config.Config) Option {
 	}
 }
 
+// Store provides a function to set the store option.
+func Store(val store.Store) Option {
+	return func(o *Options) {
+		o.Store = val
+	}
+}
+
+// Jobs provides a function to set the jobs option.
+func Jobs(val *jobs.Runner) Option {
+	return func(o *Options) {
+		o.Jobs = val
+	}
+}
+
 // Middleware provides a function to set the middleware option.
 func Middleware(val ...func(http.Handler) http.Handler) Option {
 	return func(o *Options) {
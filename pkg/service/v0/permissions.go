@@ -0,0 +1,421 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	link "github.com/cs3org/go-cs3apis/cs3/sharing/link/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// The ids of permissions are prefixed with the kind of permission, the ids
+// of shares and public links are issued by different services.
+const (
+	sharePermissionPrefix = "s:"
+	linkPermissionPrefix  = "l:"
+)
+
+// itemPermission is either a share with a user or group or a public link.
+type itemPermission struct {
+	share *collaboration.Share
+	link  *link.PublicShare
+}
+
+// listItemPermissions returns the shares and public links of a resource.
+func listItemPermissions(ctx context.Context, client gateway.GatewayAPIClient, id *storageprovider.ResourceId) ([]*collaboration.Share, []*link.PublicShare, error) {
+	sharesRes, err := client.ListShares(ctx, &collaboration.ListSharesRequest{
+		Filters: []*collaboration.ListSharesRequest_Filter{
+			{
+				Type: collaboration.ListSharesRequest_Filter_TYPE_RESOURCE_ID,
+				Term: &collaboration.ListSharesRequest_Filter_ResourceId{ResourceId: id},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if sharesRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, nil, &statusError{status: sharesRes.Status}
+	}
+
+	linksRes, err := client.ListPublicShares(ctx, &link.ListPublicSharesRequest{
+		Filters: []*link.ListPublicSharesRequest_Filter{
+			{
+				Type: link.ListPublicSharesRequest_Filter_TYPE_RESOURCE_ID,
+				Term: &link.ListPublicSharesRequest_Filter_ResourceId{ResourceId: id},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if linksRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, nil, &statusError{status: linksRes.Status}
+	}
+	return sharesRes.Shares, linksRes.Share, nil
+}
+
+// granteeIdentity looks up the grantee of a share in the directory. If
+// it can not be found, or there is no connection, the identity only
+// carries the CS3 id.
+func (g Graph) granteeIdentity(conn *ldap.Conn, grantee *storageprovider.Grantee) *msgraph.Identity {
+	opaqueID := grantee.Id.OpaqueId
+	if conn == nil {
+		return &msgraph.Identity{ID: &opaqueID}
+	}
+	baseDN, filter, nameAttr := g.config.Ldap.BaseDNUsers, fmt.Sprintf("(entryuuid=%s)", ldap.EscapeFilter(opaqueID)), "displayname"
+	if grantee.Type == storageprovider.GranteeType_GRANTEE_TYPE_GROUP {
+		baseDN, filter, nameAttr = g.config.Ldap.BaseDNGroups, fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(opaqueID)), "cn"
+	}
+
+	entry, err := g.ldapSearchSingleEntry(conn, baseDN, filter)
+	if err != nil {
+		g.logger.Debug().Err(err).Msgf("Failed to read grantee %s", opaqueID)
+		return &msgraph.Identity{ID: &opaqueID}
+	}
+	id := entry.GetAttributeValue("entryuuid")
+	displayName := entry.GetAttributeValue(nameAttr)
+	return &msgraph.Identity{
+		ID:          &id,
+		DisplayName: &displayName,
+	}
+}
+
+// renderPermission renders a share or sharing link. The grantee of a share
+// is looked up in the directory.
+func (g Graph) renderPermission(w http.ResponseWriter, r *http.Request, p *itemPermission) {
+	var permission interface{}
+	if p.link != nil {
+		permission = g.cs3PublicShareToPermission(p.link)
+	} else {
+		conn, done := g.openLdap()
+		defer done()
+		permission = cs3ShareToPermission(p.share, g.granteeIdentity(conn, p.share.Grantee))
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, permission)
+}
+
+// GetPermissions lists the shares and sharing links of the item resolved
+// by DriveItemCtx, see https://docs.microsoft.com/en-us/graph/api/driveitem-list-permissions?view=graph-rest-1.0
+func (g Graph) GetPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+
+	shares, links, err := listItemPermissions(ctx, client, statRes.Info.Id)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing permissions of %s", statRes.Info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	conn, done := g.openLdap()
+	defer done()
+
+	permissions := make([]interface{}, 0, len(shares)+len(links))
+	for _, share := range shares {
+		permissions = append(permissions, cs3ShareToPermission(share, g.granteeIdentity(conn, share.Grantee)))
+	}
+	for _, l := range links {
+		permissions = append(permissions, g.cs3PublicShareToPermission(l))
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: permissions})
+}
+
+// PermissionCtx middleware is used to load a share or sharing link of the
+// item resolved by DriveItemCtx from the URL parameters passed through as
+// the request. The prefix of the permission id tells which of them is
+// meant. In case the permission could not be found, we stop here and
+// return a 404.
+func (g Graph) PermissionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+		permissionID := chi.URLParam(r, "permissionID")
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+			renderStatus(w, r, statRes.Status)
+			return
+		}
+
+		shares, links, err := listItemPermissions(ctx, client, statRes.Info.Id)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error listing permissions of %s", statRes.Info.Path)
+			renderError(w, r, err)
+			return
+		}
+
+		var p *itemPermission
+		switch {
+		case strings.HasPrefix(permissionID, sharePermissionPrefix):
+			for _, share := range shares {
+				if share.Id.OpaqueId == strings.TrimPrefix(permissionID, sharePermissionPrefix) {
+					p = &itemPermission{share: share}
+				}
+			}
+		case strings.HasPrefix(permissionID, linkPermissionPrefix):
+			for _, l := range links {
+				if l.Id.OpaqueId == strings.TrimPrefix(permissionID, linkPermissionPrefix) {
+					p = &itemPermission{link: l}
+				}
+			}
+		}
+		if p == nil {
+			g.logger.Info().Msgf("Failed to read permission %s", permissionID)
+			errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, permissionKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetPermission returns the permission resolved by PermissionCtx.
+func (g Graph) GetPermission(w http.ResponseWriter, r *http.Request) {
+	p := r.Context().Value(permissionKey).(*itemPermission)
+	g.renderPermission(w, r, p)
+}
+
+// UpdatePermission changes the role of a share or the role, expiration and
+// password of a sharing link, see https://docs.microsoft.com/en-us/graph/api/permission-update?view=graph-rest-1.0
+func (g Graph) UpdatePermission(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p := ctx.Value(permissionKey).(*itemPermission)
+
+	req := struct {
+		Roles              []string   `json:"roles"`
+		ExpirationDateTime *time.Time `json:"expirationDateTime"`
+		Password           *string    `json:"password"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode update permission request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.ExpirationDateTime != nil && req.ExpirationDateTime.Before(time.Now()) {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	var permissions *storageprovider.ResourcePermissions
+	if len(req.Roles) > 0 {
+		role := roleRead
+		for _, rl := range req.Roles {
+			if _, err := rolePermissions(rl); err != nil {
+				g.logger.Debug().Str("role", rl).Msg("unknown sharing role")
+				errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+				return
+			}
+			if rl == roleWrite {
+				role = roleWrite
+			}
+		}
+		permissions, _ = rolePermissions(role)
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if p.share != nil {
+		if req.ExpirationDateTime != nil || req.Password != nil {
+			errorcode.NotSupported.Render(w, r, http.StatusBadRequest)
+			return
+		}
+		if permissions != nil {
+			res, err := client.UpdateShare(ctx, &collaboration.UpdateShareRequest{
+				Ref: &collaboration.ShareReference{
+					Spec: &collaboration.ShareReference_Id{Id: p.share.Id},
+				},
+				Field: &collaboration.UpdateShareRequest_UpdateField{
+					Field: &collaboration.UpdateShareRequest_UpdateField_Permissions{
+						Permissions: &collaboration.SharePermissions{Permissions: permissions},
+					},
+				},
+			})
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error sending update share grpc request %s", p.share.Id.OpaqueId)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			if res.Status.Code != cs3rpc.Code_CODE_OK {
+				g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc update share %s", p.share.Id.OpaqueId)
+				renderStatus(w, r, res.Status)
+				return
+			}
+
+			// the update response does not carry the share
+			getRes, err := client.GetShare(ctx, &collaboration.GetShareRequest{
+				Ref: &collaboration.ShareReference{
+					Spec: &collaboration.ShareReference_Id{Id: p.share.Id},
+				},
+			})
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error sending get share grpc request %s", p.share.Id.OpaqueId)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			if getRes.Status.Code != cs3rpc.Code_CODE_OK {
+				g.logger.Debug().Str("code", getRes.Status.Code.String()).Msgf("error calling grpc get share %s", p.share.Id.OpaqueId)
+				renderStatus(w, r, getRes.Status)
+				return
+			}
+			p.share = getRes.Share
+		}
+
+		g.renderPermission(w, r, p)
+		return
+	}
+
+	// a public share is updated one field at a time
+	updates := []*link.UpdatePublicShareRequest_Update{}
+	if permissions != nil {
+		if p.link.Permissions != nil && permissionsLinkType(p.link.Permissions.Permissions) == linkTypeUpload {
+			// the role of a drop folder can not be changed
+			errorcode.NotSupported.Render(w, r, http.StatusBadRequest)
+			return
+		}
+		updates = append(updates, &link.UpdatePublicShareRequest_Update{
+			Type:  link.UpdatePublicShareRequest_Update_TYPE_PERMISSIONS,
+			Grant: &link.Grant{Permissions: &link.PublicSharePermissions{Permissions: permissions}},
+		})
+	}
+	if req.ExpirationDateTime != nil {
+		updates = append(updates, &link.UpdatePublicShareRequest_Update{
+			Type: link.UpdatePublicShareRequest_Update_TYPE_EXPIRATION,
+			Grant: &link.Grant{
+				Expiration: &typespb.Timestamp{
+					Seconds: uint64(req.ExpirationDateTime.Unix()),
+					Nanos:   uint32(req.ExpirationDateTime.Nanosecond()),
+				},
+			},
+		})
+	}
+	if req.Password != nil {
+		updates = append(updates, &link.UpdatePublicShareRequest_Update{
+			Type:  link.UpdatePublicShareRequest_Update_TYPE_PASSWORD,
+			Grant: &link.Grant{Password: *req.Password},
+		})
+	}
+	for _, update := range updates {
+		res, err := client.UpdatePublicShare(ctx, &link.UpdatePublicShareRequest{
+			Ref: &link.PublicShareReference{
+				Spec: &link.PublicShareReference_Id{Id: p.link.Id},
+			},
+			Update: update,
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending update public share grpc request %s", p.link.Id.OpaqueId)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc update public share %s", p.link.Id.OpaqueId)
+			renderStatus(w, r, res.Status)
+			return
+		}
+		p.link = res.Share
+	}
+
+	g.renderPermission(w, r, p)
+}
+
+// DeletePermission revokes a share or sharing link,
+// see https://docs.microsoft.com/en-us/graph/api/permission-delete?view=graph-rest-1.0
+func (g Graph) DeletePermission(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p := ctx.Value(permissionKey).(*itemPermission)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	var status *cs3rpc.Status
+	if p.share != nil {
+		res, err := client.RemoveShare(ctx, &collaboration.RemoveShareRequest{
+			Ref: &collaboration.ShareReference{
+				Spec: &collaboration.ShareReference_Id{Id: p.share.Id},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending remove share grpc request %s", p.share.Id.OpaqueId)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		status = res.Status
+	} else {
+		res, err := client.RemovePublicShare(ctx, &link.RemovePublicShareRequest{
+			Ref: &link.PublicShareReference{
+				Spec: &link.PublicShareReference_Id{Id: p.link.Id},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending remove public share grpc request %s", p.link.Id.OpaqueId)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		status = res.Status
+	}
+	if status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", status.Code.String()).Msg("error calling grpc remove share")
+		renderStatus(w, r, status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package svc
+
+import (
+	"context"
+	"errors"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// errQuotaExceeded is returned if an upload does not fit into the quota of a drive.
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// quotaState returns the state of a quota as defined by MS Graph. Nearing
+// starts with less than 10% of the quota remaining, critical with less
+// than 1%.
+func quotaState(total, used uint64) string {
+	switch {
+	case total == 0:
+		return "normal"
+	case used >= total:
+		return "exceeded"
+	case (total-used)*100 < total:
+		return "critical"
+	case (total-used)*10 < total:
+		return "nearing"
+	default:
+		return "normal"
+	}
+}
+
+// getQuota returns the quota of the storage the drive root belongs to. A
+// total of 0 means the storage has no limit.
+func getQuota(ctx context.Context, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo) (total, used uint64, err error) {
+	res, err := client.GetQuota(ctx, &gateway.GetQuotaRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: root.Path},
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return 0, 0, &statusError{status: res.Status}
+	}
+	return res.TotalBytes, res.UsedBytes, nil
+}
+
+// cs3QuotaToGraph converts a CS3 quota into a quota facet.
+func cs3QuotaToGraph(total, used uint64) *msgraph.Quota {
+	state := quotaState(total, used)
+	quota := &msgraph.Quota{
+		Used:  new(int),
+		State: &state,
+	}
+	*quota.Used = int(used) // uint64 -> int :boom:
+	if total > 0 {
+		quota.Total = new(int)
+		*quota.Total = int(total)
+		quota.Remaining = new(int)
+		if used < total {
+			*quota.Remaining = int(total - used)
+		}
+	}
+	return quota
+}
+
+// remainingQuota returns how many bytes can be written to the drive when
+// replacing a file of the given size, -1 if the storage does not report a
+// quota.
+func remainingQuota(ctx context.Context, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo, replaced uint64) (int64, error) {
+	total, used, err := getQuota(ctx, client, root)
+	if err != nil {
+		if _, ok := err.(*statusError); ok {
+			return -1, nil
+		}
+		return 0, err
+	}
+	if total == 0 {
+		return -1, nil
+	}
+	var free uint64
+	if used < total {
+		free = total - used
+	}
+	return int64(free + replaced), nil
+}
+
+// checkQuota returns errQuotaExceeded if writing length bytes, replacing
+// a file of the given size, would exceed the quota of the drive. Storages
+// that do not report a quota are not checked.
+func checkQuota(ctx context.Context, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo, replaced uint64, length int64) error {
+	remaining, err := remainingQuota(ctx, client, root, replaced)
+	if err != nil {
+		return err
+	}
+	if remaining >= 0 && length > remaining {
+		return errQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"google.golang.org/grpc"
+)
+
+func TestQuotaState(t *testing.T) {
+	tests := []struct {
+		total, used uint64
+		want        string
+	}{
+		{0, 0, "normal"},
+		{0, 1000, "normal"},
+		{1000, 0, "normal"},
+		{1000, 900, "normal"},
+		{1000, 901, "nearing"},
+		{1000, 990, "nearing"},
+		{1000, 991, "critical"},
+		{1000, 999, "critical"},
+		{1000, 1000, "exceeded"},
+		{1000, 2000, "exceeded"},
+	}
+
+	for _, tt := range tests {
+		if got := quotaState(tt.total, tt.used); got != tt.want {
+			t.Errorf("quotaState(%d, %d) = %q, want %q", tt.total, tt.used, got, tt.want)
+		}
+	}
+}
+
+// quotaGateway fakes the quota of a storage. It responds with code if it
+// is set.
+type quotaGateway struct {
+	gateway.GatewayAPIClient
+	total, used uint64
+	code        cs3rpc.Code
+	err         error
+}
+
+func (c *quotaGateway) GetQuota(ctx context.Context, in *gateway.GetQuotaRequest, opts ...grpc.CallOption) (*storageprovider.GetQuotaResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	status := &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK}
+	if c.code != cs3rpc.Code_CODE_INVALID {
+		status.Code = c.code
+	}
+	return &storageprovider.GetQuotaResponse{
+		Status:     status,
+		TotalBytes: c.total,
+		UsedBytes:  c.used,
+	}, nil
+}
+
+func TestCheckQuota(t *testing.T) {
+	errTransport := errors.New("transport error")
+
+	tests := []struct {
+		name     string
+		client   *quotaGateway
+		replaced uint64
+		length   int64
+		want     error
+	}{
+		{"fits", &quotaGateway{total: 100, used: 50}, 0, 50, nil},
+		{"exceeds", &quotaGateway{total: 100, used: 50}, 0, 51, errQuotaExceeded},
+		{"fits replacing a file", &quotaGateway{total: 100, used: 90}, 40, 50, nil},
+		{"exceeds replacing a file", &quotaGateway{total: 100, used: 90}, 40, 51, errQuotaExceeded},
+		{"already exceeded", &quotaGateway{total: 100, used: 150}, 0, 1, errQuotaExceeded},
+		{"empty file on a full storage", &quotaGateway{total: 100, used: 100}, 0, 0, nil},
+		{"no limit", &quotaGateway{used: 1 << 40}, 0, 1 << 40, nil},
+		{"no quota support", &quotaGateway{code: cs3rpc.Code_CODE_UNIMPLEMENTED}, 0, 1 << 40, nil},
+		{"transport error", &quotaGateway{err: errTransport}, 0, 1, errTransport},
+	}
+
+	root := &storageprovider.ResourceInfo{Path: "/home"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkQuota(context.Background(), tt.client, root, tt.replaced, tt.length); err != tt.want {
+				t.Errorf("checkQuota() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCs3QuotaToGraph(t *testing.T) {
+	q := cs3QuotaToGraph(100, 150)
+	if q.Total == nil || *q.Total != 100 || *q.Used != 150 || q.Remaining == nil || *q.Remaining != 0 || *q.State != "exceeded" {
+		t.Errorf("cs3QuotaToGraph(100, 150) = %+v", q)
+	}
+
+	q = cs3QuotaToGraph(0, 150)
+	if q.Total != nil || q.Remaining != nil || *q.Used != 150 || *q.State != "normal" {
+		t.Errorf("cs3QuotaToGraph(0, 150) = %+v", q)
+	}
+}
@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"container/heap"
+	"net/http"
+	"sort"
+
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+)
+
+// recentMaxItems is the number of files listed as recent.
+const recentMaxItems = 100
+
+// modifiedBefore checks if a was modified before b.
+func modifiedBefore(a, b *storageprovider.ResourceInfo) bool {
+	if a.Mtime.Seconds != b.Mtime.Seconds {
+		return a.Mtime.Seconds < b.Mtime.Seconds
+	}
+	return a.Mtime.Nanos < b.Mtime.Nanos
+}
+
+// recentFiles is a min heap of files by their modification time, the
+// oldest of the collected files is on top.
+type recentFiles []*storageprovider.ResourceInfo
+
+func (h recentFiles) Len() int            { return len(h) }
+func (h recentFiles) Less(i, j int) bool  { return modifiedBefore(h[i], h[j]) }
+func (h recentFiles) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *recentFiles) Push(x interface{}) { *h = append(*h, x.(*storageprovider.ResourceInfo)) }
+func (h *recentFiles) Pop() interface{} {
+	old := *h
+	info := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return info
+}
+
+// GetRecentDriveItems lists the most recently modified files of the drive
+// resolved by DriveCtx. Like search, only the first searchMaxListings (1000)
+// folders are looked at, files in larger drives may be missing. If the
+// storages propagate modifications to the mtime of the parent folders, see
+// Recent.MtimePropagation, folders older than the recentMaxItems newest
+// files found so far are skipped,
+// see https://docs.microsoft.com/en-us/graph/api/drive-recent?view=graph-rest-1.0
+func (g Graph) GetRecentDriveItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	files := &recentFiles{}
+	err = walkTree(ctx, client, root.Path, searchMaxListings, func(info *storageprovider.ResourceInfo) error {
+		full := files.Len() == recentMaxItems
+		switch {
+		case info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER:
+			if full && g.config.Recent.MtimePropagation && modifiedBefore(info, (*files)[0]) {
+				return errSkipFolder
+			}
+		case info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE:
+		case !full:
+			heap.Push(files, info)
+		case modifiedBefore((*files)[0], info):
+			(*files)[0] = info
+			heap.Fix(files, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing %s", root.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	sort.Sort(sort.Reverse(files))
+
+	items, err := formatDriveItems(*files, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: items})
+}
@@ -0,0 +1,307 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	"github.com/owncloud/ocis-graph/pkg/store"
+)
+
+const (
+	// searchMaxListings bounds the number of folders listed by a search.
+	searchMaxListings = 1000
+	// searchMaxResults bounds the number of hits a search collects.
+	searchMaxResults = 1000
+	// searchPageSize is the default number of hits per page.
+	searchPageSize = 100
+	// searchPositionTTL defines how long the next link of a search is valid.
+	searchPositionTTL = time.Hour
+	// searchStorePrefix is the prefix of the store keys of search positions.
+	searchStorePrefix = "search/"
+)
+
+// parseSearchQuery extracts the search text from the escaped parameter of
+// the OData search function, e.g. q='annual%20report'). Quotes in the text
+// are escaped by doubling them.
+func parseSearchQuery(s string) (string, bool) {
+	s, err := url.PathUnescape(s)
+	if err != nil || !strings.HasPrefix(s, "q=") || !strings.HasSuffix(s, ")") {
+		return "", false
+	}
+	q := strings.TrimSuffix(strings.TrimPrefix(s, "q="), ")")
+	if len(q) < 2 || !strings.HasPrefix(q, "'") || !strings.HasSuffix(q, "'") {
+		return "", false
+	}
+	return strings.Replace(q[1:len(q)-1], "''", "'", -1), true
+}
+
+// errSkipFolder is returned by the visit func of walkTree to leave out the
+// resources below a folder.
+var errSkipFolder = errors.New("skip folder")
+
+// walkTree walks the tree below folder breadth first and calls visit for
+// every resource. The walk does not descend into folders visit returns
+// errSkipFolder for, any other error stops the walk and is returned. The
+// walk stops after maxListings folders, unless it is 0.
+func walkTree(ctx context.Context, client gateway.GatewayAPIClient, folder string, maxListings int, visit func(*storageprovider.ResourceInfo) error) error {
+	queue := []string{folder}
+	for listings := 0; len(queue) > 0 && (maxListings == 0 || listings < maxListings); listings++ {
+		res, err := client.ListContainer(ctx, &storageprovider.ListContainerRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: queue[0]},
+			},
+			ArbitraryMetadataKeys: driveItemMetadataKeys,
+		})
+		if err != nil {
+			return err
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			return &statusError{status: res.Status}
+		}
+		queue = queue[1:]
+
+		for _, info := range res.Infos {
+			switch err := visit(info); {
+			case err == errSkipFolder:
+				continue
+			case err != nil:
+				return err
+			}
+			if info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+				queue = append(queue, info.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// searchPosition is the position of a paged search in the walk of the
+// tree below Folder. It is kept in the store, the skip token of the next
+// page is its key. The walk continues with the entry Offset of the first
+// of the folders. The listings and hits of the previous pages count
+// towards the limits of the search.
+type searchPosition struct {
+	User     string   `json:"user"`
+	Folder   string   `json:"folder"`
+	Folders  []string `json:"folders"`
+	Offset   int      `json:"offset"`
+	Listings int      `json:"listings"`
+	Hits     int      `json:"hits"`
+}
+
+// searchPositionExpired checks the expiration encoded in the store key of
+// a search position.
+func searchPositionExpired(key string) bool {
+	return expiringTokenExpired(strings.TrimPrefix(key, searchStorePrefix))
+}
+
+// writeSearchPosition stores pos and returns the skip token of the next page.
+func (g Graph) writeSearchPosition(pos *searchPosition) (string, error) {
+	token, err := newExpiringToken(time.Now().Add(searchPositionTTL))
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(pos)
+	if err != nil {
+		return "", err
+	}
+	return token, g.store.Write(searchStorePrefix+token, b)
+}
+
+// readSearchPosition returns the position of a skip token. It returns
+// store.ErrNotFound for unknown and expired tokens.
+func (g Graph) readSearchPosition(token string) (*searchPosition, error) {
+	if searchPositionExpired(token) {
+		return nil, store.ErrNotFound
+	}
+	b, err := g.store.Read(searchStorePrefix + token)
+	if err != nil {
+		return nil, err
+	}
+	pos := &searchPosition{}
+	if err := json.Unmarshal(b, pos); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// validSearchPosition checks that pos continues a search of user below
+// folder. The walk can not leave the folder.
+func validSearchPosition(pos *searchPosition, user, folder string) bool {
+	if pos.User != user || pos.Folder != folder || pos.Offset < 0 {
+		return false
+	}
+	for _, f := range pos.Folders {
+		if f != path.Clean(f) {
+			return false
+		}
+		if f != folder && !strings.HasPrefix(f, strings.TrimSuffix(folder, "/")+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTree continues the walk at pos and returns up to top resources with
+// a name containing q. pos is moved to the next page, no folders are left
+// when the walk is done. A folder that is only partly visited is listed
+// again by the next page, the walk relies on the stable order of listings.
+// The walk stops after searchMaxListings folders or searchMaxResults hits.
+func searchTree(ctx context.Context, client gateway.GatewayAPIClient, pos *searchPosition, q string, top int) ([]*storageprovider.ResourceInfo, error) {
+	q = strings.ToLower(q)
+	hits := []*storageprovider.ResourceInfo{}
+	for len(pos.Folders) > 0 && len(hits) < top {
+		if pos.Listings >= searchMaxListings || pos.Hits >= searchMaxResults {
+			pos.Folders = nil
+			break
+		}
+
+		res, err := client.ListContainer(ctx, &storageprovider.ListContainerRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: pos.Folders[0]},
+			},
+			ArbitraryMetadataKeys: driveItemMetadataKeys,
+		})
+		if err != nil {
+			return nil, err
+		}
+		pos.Listings++
+		switch res.Status.Code {
+		case cs3rpc.Code_CODE_OK:
+		case cs3rpc.Code_CODE_NOT_FOUND:
+			// removed since the previous page
+			pos.Folders, pos.Offset = pos.Folders[1:], 0
+			continue
+		default:
+			return nil, &statusError{status: res.Status}
+		}
+
+		i := pos.Offset
+		for ; i < len(res.Infos) && len(hits) < top && pos.Hits < searchMaxResults; i++ {
+			info := res.Infos[i]
+			if info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+				pos.Folders = append(pos.Folders, info.Path)
+			}
+			if strings.Contains(strings.ToLower(path.Base(info.Path)), q) {
+				hits = append(hits, info)
+				pos.Hits++
+			}
+		}
+		if i < len(res.Infos) {
+			pos.Offset = i
+		} else {
+			pos.Folders, pos.Offset = pos.Folders[1:], 0
+		}
+	}
+	if pos.Hits >= searchMaxResults {
+		pos.Folders = nil
+	}
+	return hits, nil
+}
+
+// SearchDriveItems searches the tree below the folder resolved by
+// DriveItemCtx for items with a matching name. The results are paged with
+// $top and $skipToken, see https://docs.microsoft.com/en-us/graph/api/driveitem-search?view=graph-rest-1.0
+func (g Graph) SearchDriveItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	// the parameter is cut from the escaped path, so it is unescaped once
+	param := strings.TrimPrefix(escapedSuffix(r, "/search("+chi.URLParam(r, "*")), "/search(")
+	q, ok := parseSearchQuery(param)
+	if !ok || q == "" {
+		g.logger.Debug().Str("query", param).Msg("invalid search query")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	top, ok := parseTop(query, searchPageSize)
+	if !ok {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user := revaUserID(ctx)
+	pos := &searchPosition{User: user, Folder: statRes.Info.Path, Folders: []string{statRes.Info.Path}}
+	if token := query.Get("$skipToken"); token != "" {
+		pos, err = g.readSearchPosition(token)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			g.logger.Debug().Str("token", token).Msg("unknown or expired search skip token")
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		case err != nil:
+			g.logger.Error().Err(err).Msgf("error reading search skip token %s", token)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		case !validSearchPosition(pos, user, statRes.Info.Path):
+			g.logger.Debug().Str("token", token).Msg("invalid search skip token")
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+	}
+
+	hits, err := searchTree(ctx, client, pos, q, top)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error searching %s", statRes.Info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	resp := &listResponse{}
+	if resp.Value, err = formatDriveItems(hits, root); err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if len(pos.Folders) > 0 {
+		token, err := g.writeSearchPosition(pos)
+		if err != nil {
+			g.logger.Error().Err(err).Msg("error writing search skip token")
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		query.Set("$skipToken", token)
+		resp.NextLink = g.linkURL(r, query)
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
@@ -0,0 +1,150 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	"google.golang.org/grpc"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		param string
+		want  string
+		ok    bool
+	}{
+		{"q='report')", "report", true},
+		{"q='annual%20report')", "annual report", true},
+		{"q='it''s')", "it's", true},
+		{"q='100%25')", "100%", true},
+		{"q='%2541')", "%41", true},
+		{"q='a:b')", "a:b", true},
+		{"q='')", "", true},
+		{"q=report)", "", false},
+		{"q='report'", "", false},
+		{"x='report')", "", false},
+		{"q=')", "", false},
+		{"q='a%zz')", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			got, ok := parseSearchQuery(tt.param)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("parseSearchQuery(%q) = %q, %v, want %q, %v", tt.param, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// treeGateway fakes the listings of a tree of folders.
+type treeGateway struct {
+	gateway.GatewayAPIClient
+	folders map[string][]string
+}
+
+func (c *treeGateway) ListContainer(ctx context.Context, in *storageprovider.ListContainerRequest, opts ...grpc.CallOption) (*storageprovider.ListContainerResponse, error) {
+	res := &storageprovider.ListContainerResponse{
+		Status: &cs3rpc.Status{Code: cs3rpc.Code_CODE_OK},
+	}
+	for _, name := range c.folders[in.Ref.GetPath()] {
+		p := path.Join(in.Ref.GetPath(), name)
+		info := &storageprovider.ResourceInfo{Path: p, Type: storageprovider.ResourceType_RESOURCE_TYPE_FILE}
+		if _, ok := c.folders[p]; ok {
+			info.Type = storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER
+		}
+		res.Infos = append(res.Infos, info)
+	}
+	return res, nil
+}
+
+func TestSearchTreePages(t *testing.T) {
+	client := &treeGateway{folders: map[string][]string{
+		"/home":           {"a-report", "docs", "b-report", "c"},
+		"/home/docs":      {"d-report", "e", "f-report"},
+		"/home/unrelated": {"g-report"},
+	}}
+
+	g := Graph{store: store.NewMemory()}
+	var got []string
+	pos := &searchPosition{User: "einstein", Folder: "/home", Folders: []string{"/home"}}
+	for pages := 0; len(pos.Folders) > 0; pages++ {
+		if pages > 10 {
+			t.Fatal("search does not end")
+		}
+		hits, err := searchTree(context.Background(), client, pos, "report", 1)
+		if err != nil {
+			t.Fatalf("searchTree() error = %v", err)
+		}
+		if len(hits) > 1 {
+			t.Fatalf("searchTree() returned %d hits, want at most 1", len(hits))
+		}
+		for _, h := range hits {
+			got = append(got, h.Path)
+		}
+
+		// the position survives the round trip through the store
+		token, err := g.writeSearchPosition(pos)
+		if err != nil {
+			t.Fatalf("writeSearchPosition() error = %v", err)
+		}
+		if pos, err = g.readSearchPosition(token); err != nil {
+			t.Fatalf("readSearchPosition(%q) error = %v", token, err)
+		}
+		if !validSearchPosition(pos, "einstein", "/home") {
+			t.Fatalf("validSearchPosition(%v) = false", pos)
+		}
+	}
+
+	want := []string{"/home/a-report", "/home/b-report", "/home/docs/d-report", "/home/docs/f-report"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchTree() pages = %v, want %v", got, want)
+	}
+}
+
+func TestValidSearchPosition(t *testing.T) {
+	tests := []struct {
+		name    string
+		folder  string
+		folders []string
+		user    string
+		offset  int
+		valid   bool
+	}{
+		{"below folder", "/home", []string{"/home", "/home/docs"}, "einstein", 0, true},
+		{"root folder", "/", []string{"/", "/docs"}, "einstein", 0, true},
+		{"outside folder", "/home", []string{"/home/docs", "/homework"}, "einstein", 0, false},
+		{"not clean", "/home", []string{"/home/../etc"}, "einstein", 0, false},
+		{"trailing slash", "/home", []string{"/home/docs/"}, "einstein", 0, false},
+		{"other user", "/home", []string{"/home"}, "marie", 0, false},
+		{"negative offset", "/home", []string{"/home"}, "einstein", -1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := &searchPosition{User: tt.user, Folder: tt.folder, Folders: tt.folders, Offset: tt.offset}
+			if valid := validSearchPosition(pos, "einstein", tt.folder); valid != tt.valid {
+				t.Errorf("validSearchPosition() = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+
+	pos := &searchPosition{User: "einstein", Folder: "/home/docs", Folders: []string{"/home/docs"}}
+	if validSearchPosition(pos, "einstein", "/home") {
+		t.Error("validSearchPosition() accepted the position of another search")
+	}
+}
+
+func TestReadSearchPositionExpired(t *testing.T) {
+	g := Graph{store: store.NewMemory()}
+	if _, err := g.readSearchPosition("1-00"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("readSearchPosition() error = %v, want %v", err, store.ErrNotFound)
+	}
+}
@@ -2,9 +2,12 @@ package svc
 
 import (
 	"net/http"
+	"runtime"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/owncloud/ocis-graph/pkg/jobs"
+	"github.com/owncloud/ocis-graph/pkg/store"
 )
 
 // Service defines the extension handlers.
@@ -22,10 +25,100 @@ func NewService(opts ...Option) Service {
 	m := chi.NewMux()
 	m.Use(options.Middleware...)
 
+	if options.Store == nil {
+		options.Store = store.NewMemory()
+	}
+
+	if options.Jobs == nil {
+		options.Jobs = jobs.NewRunner(jobs.Logger(options.Logger))
+		go options.Jobs.Run()
+	}
+
 	svc := Graph{
 		config: options.Config,
 		mux:    m,
 		logger: &options.Logger,
+		store:  options.Store,
+		jobs:   options.Jobs,
+
+		importClient: newImportClient(options.Config.Import.AllowedHosts, publicIP),
+		dataClient:   newDataClient(),
+		uploadLocks:  newKeyLocks(),
+		deltaLocks:   newKeyLocks(),
+
+		thumbnailSlots: make(chan struct{}, runtime.NumCPU()),
+	}
+	go svc.sweep()
+
+	driveItemRoutes := func(r chi.Router) {
+		r.Use(svc.DriveItemCtx)
+		r.Get("/", svc.GetDriveItem)
+		r.Patch("/", svc.UpdateDriveItem)
+		r.Delete("/", svc.DeleteDriveItem)
+		r.Get("/children", svc.GetDriveItemChildren)
+		r.Get("/delta", svc.GetDriveItemDelta)
+		r.Get("/search(*", svc.SearchDriveItems)
+		r.Route("/versions", func(r chi.Router) {
+			r.Get("/", svc.GetVersions)
+			r.Route("/{versionID}", func(r chi.Router) {
+				r.Use(svc.VersionCtx)
+				r.Get("/", svc.GetVersion)
+				r.Get("/content", svc.GetVersionContent)
+				r.Post("/restoreVersion", svc.RestoreVersion)
+			})
+		})
+		r.Route("/thumbnails", func(r chi.Router) {
+			r.Get("/", svc.GetThumbnailSets)
+			r.Get("/{setID}/{size}", svc.GetThumbnail)
+			r.Get("/{setID}/{size}/content", svc.GetThumbnailContent)
+		})
+		r.Post("/children", svc.CreateDriveItem)
+		r.Get("/content", svc.GetDriveItemContent)
+		r.Put("/content", svc.PutDriveItemContent)
+		r.Post("/createUploadSession", svc.CreateUploadSession)
+		r.Post("/copy", svc.CopyDriveItem)
+		r.Post("/checkout", svc.CheckoutDriveItem)
+		r.Post("/checkin", svc.CheckinDriveItem)
+		r.Post("/follow", svc.FollowDriveItem)
+		r.Post("/unfollow", svc.UnfollowDriveItem)
+		r.Post("/invite", svc.InviteDriveItem)
+		r.Post("/createLink", svc.CreateLink)
+		r.Route("/extensions", func(r chi.Router) {
+			r.Get("/", svc.GetExtensions)
+			r.Post("/", svc.CreateExtension)
+			r.Route("/{extensionName}", func(r chi.Router) {
+				r.Use(svc.ExtensionCtx)
+				r.Get("/", svc.GetExtension)
+				r.Patch("/", svc.UpdateExtension)
+				r.Delete("/", svc.DeleteExtension)
+			})
+		})
+		r.Route("/permissions", func(r chi.Router) {
+			r.Get("/", svc.GetPermissions)
+			r.Route("/{permissionID}", func(r chi.Router) {
+				r.Use(svc.PermissionCtx)
+				r.Get("/", svc.GetPermission)
+				r.Patch("/", svc.UpdatePermission)
+				r.Delete("/", svc.DeletePermission)
+			})
+		})
+	}
+	driveRoutes := func(r chi.Router) {
+		r.Use(svc.RevaCtx)
+		r.Use(svc.DriveCtx)
+		r.Use(svc.PathCtx)
+		r.Get("/", svc.GetDrive)
+		r.Route("/root", driveItemRoutes)
+		r.Route("/items/{itemID}", driveItemRoutes)
+		r.Route("/recycleBin", func(r chi.Router) {
+			r.Get("/", svc.GetRecycleBin)
+			r.Delete("/", svc.EmptyRecycleBin)
+			r.Route("/{recycleItemID}", func(r chi.Router) {
+				r.Use(svc.RecycleItemCtx)
+				r.Delete("/", svc.PurgeRecycleItem)
+				r.Post("/restore", svc.RestoreRecycleItem)
+			})
+		})
 	}
 
 	m.Route(options.Config.HTTP.Root, func(r chi.Router) {
@@ -33,8 +126,35 @@ func NewService(opts ...Option) Service {
 		r.Route("/v1.0", func(r chi.Router) {
 			r.Route("/me", func(r chi.Router) {
 				r.Get("/", svc.GetMe)
-				r.Get("/drive/root/children", svc.GetRootDriveChildren)
+				r.Route("/drive", func(r chi.Router) {
+					driveRoutes(r)
+					r.Get("/recent", svc.GetRecentDriveItems)
+					r.Get("/following", svc.GetFollowing)
+					r.Route("/sharedWithMe", func(r chi.Router) {
+						r.Get("/", svc.GetSharedWithMe)
+						r.Route("/{shareID}", func(r chi.Router) {
+							r.Use(svc.ReceivedShareCtx)
+							r.Post("/accept", svc.AcceptReceivedShare)
+							r.Post("/decline", svc.DeclineReceivedShare)
+						})
+					})
+					r.Route("/special/{specialFolderName}", func(r chi.Router) {
+						r.Use(svc.SpecialFolderCtx)
+						r.Get("/", svc.GetSpecialFolder)
+						r.Get("/children", svc.GetDriveItemChildren)
+					})
+				})
+				r.With(svc.RevaCtx).Get("/drives", svc.GetDrives)
+			})
+			r.With(svc.RevaCtx).Get("/drives", svc.GetDrives)
+			r.Route("/drives/{driveID}", driveRoutes)
+			r.Route("/uploadSessions/{sessionID}", func(r chi.Router) {
+				r.Use(svc.UploadSessionCtx)
+				r.Get("/", svc.GetUploadSession)
+				r.Put("/", svc.PutUploadSession)
+				r.Delete("/", svc.DeleteUploadSession)
 			})
+			r.Get("/monitor/{jobID}", svc.GetMonitor)
 			r.Route("/users", func(r chi.Router) {
 				r.Get("/", svc.GetUsers)
 				r.Route("/{userID}", func(r chi.Router) {
@@ -0,0 +1,292 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// receivedShareItem is a drive item for a received share. MS Graph has no
+// notion of pending shares, so the state is added as instance annotation.
+type receivedShareItem struct {
+	*msgraph.DriveItem
+	ShareState string `json:"@ocis.shareState"`
+}
+
+func shareStateString(s collaboration.ShareState) string {
+	switch s {
+	case collaboration.ShareState_SHARE_STATE_PENDING:
+		return "pending"
+	case collaboration.ShareState_SHARE_STATE_ACCEPTED:
+		return "accepted"
+	case collaboration.ShareState_SHARE_STATE_REJECTED:
+		return "declined"
+	default:
+		return "invalid"
+	}
+}
+
+// userIdentities looks up the owners and creators of received shares in
+// one directory search. Users that can not be found only carry their CS3
+// id, see userIdentity.
+func (g Graph) userIdentities(shares []*collaboration.ReceivedShare) map[string]*msgraph.Identity {
+	identities := map[string]*msgraph.Identity{}
+	filter := ""
+	for _, rs := range shares {
+		for _, u := range []*userpb.UserId{rs.Share.Owner, rs.Share.Creator} {
+			if u != nil {
+				filter += fmt.Sprintf("(entryuuid=%s)", ldap.EscapeFilter(u.OpaqueId))
+			}
+		}
+	}
+	if filter == "" {
+		return identities
+	}
+
+	conn, done := g.openLdap()
+	defer done()
+	if conn == nil {
+		return identities
+	}
+	result, err := g.ldapSearch(conn, "(|"+filter+")", g.config.Ldap.BaseDNUsers)
+	if err != nil {
+		g.logger.Error().Err(err).Msg("Failed to read share owners")
+		return identities
+	}
+	for _, entry := range result.Entries {
+		id := entry.GetAttributeValue("entryuuid")
+		displayName := entry.GetAttributeValue("displayname")
+		identities[id] = &msgraph.Identity{
+			ID:          &id,
+			DisplayName: &displayName,
+		}
+	}
+	return identities
+}
+
+// userIdentity returns the identity of a user looked up by userIdentities.
+func userIdentity(identities map[string]*msgraph.Identity, u *userpb.UserId) *msgraph.Identity {
+	if identity, ok := identities[u.OpaqueId]; ok {
+		return identity
+	}
+	return &msgraph.Identity{ID: &u.OpaqueId}
+}
+
+// receivedShareToDriveItem converts a received share into a drive item with
+// a remote item facet. The id of the drive item is the id of the share. The
+// remote item has no drive reference, neither the drive of the sharer nor
+// the shared resource can be addressed as a drive by the recipient.
+func (g Graph) receivedShareToDriveItem(ctx context.Context, client gateway.GatewayAPIClient, identities map[string]*msgraph.Identity, rs *collaboration.ReceivedShare) *receivedShareItem {
+	share := rs.Share
+	id := share.Id.OpaqueId
+	remoteID := wrapResourceID(share.ResourceId)
+	owner := userIdentity(identities, share.Owner)
+
+	remote := &msgraph.RemoteItem{
+		ID: &remoteID,
+		Shared: &msgraph.Shared{
+			Owner: &msgraph.IdentitySet{User: owner},
+		},
+	}
+	if share.Creator != nil {
+		remote.Shared.SharedBy = &msgraph.IdentitySet{
+			User: userIdentity(identities, share.Creator),
+		}
+	}
+	if share.Ctime != nil {
+		shared := time.Unix(int64(share.Ctime.Seconds), int64(share.Ctime.Nanos))
+		remote.Shared.SharedDateTime = &shared
+	}
+
+	item := &msgraph.DriveItem{
+		BaseItem: msgraph.BaseItem{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+		},
+		RemoteItem: remote,
+	}
+
+	// pending shares can not always be accessed yet, render what we know
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Id{Id: share.ResourceId},
+		},
+	})
+	if err != nil || statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Err(err).Msgf("could not stat shared resource %s", remoteID)
+		return &receivedShareItem{DriveItem: item, ShareState: shareStateString(rs.State)}
+	}
+	info := statRes.Info
+	name := path.Base(info.Path)
+	size := new(int)
+	*size = int(info.Size) // uint64 -> int :boom:
+	lastModified := time.Unix(int64(info.Mtime.Seconds), int64(info.Mtime.Nanos))
+
+	item.Name = &name
+	item.Size = size
+	item.LastModifiedDateTime = &lastModified
+	remote.Name = &name
+	remote.Size = size
+	remote.LastModifiedDateTime = &lastModified
+	if info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+		remote.Folder = &msgraph.Folder{}
+	} else {
+		remote.File = &msgraph.File{MimeType: &info.MimeType}
+	}
+	return &receivedShareItem{DriveItem: item, ShareState: shareStateString(rs.State)}
+}
+
+// GetSharedWithMe lists the items shared with the user. Only the shares of
+// the requested page are stated and looked up in the directory,
+// see https://docs.microsoft.com/en-us/graph/api/drive-sharedwithme?view=graph-rest-1.0
+func (g Graph) GetSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	top, skip, ok := parsePage(query, maxPageSize)
+	if !ok {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.ListReceivedShares(ctx, &collaboration.ListReceivedSharesRequest{})
+	if err != nil {
+		g.logger.Error().Err(err).Msg("error sending list received shares grpc request")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msg("error calling grpc list received shares")
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	resp := &listResponse{}
+	shares := []*collaboration.ReceivedShare{}
+	if skip < len(res.Shares) {
+		end := skip + top
+		if end < len(res.Shares) {
+			query.Set("$skipToken", strconv.Itoa(end))
+			resp.NextLink = g.linkURL(r, query)
+		} else {
+			end = len(res.Shares)
+		}
+		shares = res.Shares[skip:end]
+	}
+
+	identities := g.userIdentities(shares)
+	items := make([]*receivedShareItem, 0, len(shares))
+	for _, rs := range shares {
+		items = append(items, g.receivedShareToDriveItem(ctx, client, identities, rs))
+	}
+	resp.Value = items
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, resp)
+}
+
+// ReceivedShareCtx middleware is used to load a received share from the
+// URL parameters passed through as the request. In case the share could
+// not be found, we stop here and return a 404.
+func (g Graph) ReceivedShareCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		shareID := chi.URLParam(r, "shareID")
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		res, err := client.GetReceivedShare(ctx, &collaboration.GetReceivedShareRequest{
+			Ref: &collaboration.ShareReference{
+				Spec: &collaboration.ShareReference_Id{
+					Id: &collaboration.ShareId{OpaqueId: shareID},
+				},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending get received share grpc request %s", shareID)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Info().Str("code", res.Status.Code.String()).Msgf("Failed to read received share %s", shareID)
+			renderStatus(w, r, res.Status)
+			return
+		}
+
+		ctx = context.WithValue(ctx, receivedShareKey, res.Share)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AcceptReceivedShare accepts the share resolved by ReceivedShareCtx.
+func (g Graph) AcceptReceivedShare(w http.ResponseWriter, r *http.Request) {
+	g.updateReceivedShareState(w, r, collaboration.ShareState_SHARE_STATE_ACCEPTED)
+}
+
+// DeclineReceivedShare declines the share resolved by ReceivedShareCtx.
+func (g Graph) DeclineReceivedShare(w http.ResponseWriter, r *http.Request) {
+	g.updateReceivedShareState(w, r, collaboration.ShareState_SHARE_STATE_REJECTED)
+}
+
+func (g Graph) updateReceivedShareState(w http.ResponseWriter, r *http.Request, state collaboration.ShareState) {
+	ctx := r.Context()
+	rs := ctx.Value(receivedShareKey).(*collaboration.ReceivedShare)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.UpdateReceivedShare(ctx, &collaboration.UpdateReceivedShareRequest{
+		Ref: &collaboration.ShareReference{
+			Spec: &collaboration.ShareReference_Id{Id: rs.Share.Id},
+		},
+		Field: &collaboration.UpdateReceivedShareRequest_UpdateField{
+			Field: &collaboration.UpdateReceivedShareRequest_UpdateField_State{State: state},
+		},
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending update received share grpc request %s", rs.Share.Id.OpaqueId)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc update received share %s", rs.Share.Id.OpaqueId)
+		renderStatus(w, r, res.Status)
+		return
+	}
+	rs.State = state
+
+	shares := []*collaboration.ReceivedShare{rs}
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, g.receivedShareToDriveItem(ctx, client, g.userIdentities(shares), rs))
+}
@@ -0,0 +1,288 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	userpb "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	collaboration "github.com/cs3org/go-cs3apis/cs3/sharing/collaboration/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+const (
+	roleRead  = "read"
+	roleWrite = "write"
+)
+
+var errUnknownRole = errors.New("unknown role")
+
+// rolePermissions maps a MS Graph sharing role to CS3 permissions.
+func rolePermissions(role string) (*storageprovider.ResourcePermissions, error) {
+	p := &storageprovider.ResourcePermissions{
+		GetPath:              true,
+		GetQuota:             true,
+		InitiateFileDownload: true,
+		ListContainer:        true,
+		ListFileVersions:     true,
+		Stat:                 true,
+	}
+	switch role {
+	case roleRead:
+	case roleWrite:
+		p.CreateContainer = true
+		p.Delete = true
+		p.InitiateFileUpload = true
+		p.ListRecycle = true
+		p.Move = true
+		p.RestoreFileVersion = true
+		p.RestoreRecycleItem = true
+	default:
+		return nil, errUnknownRole
+	}
+	return p, nil
+}
+
+// permissionsRole maps CS3 permissions to the MS Graph sharing role.
+func permissionsRole(p *storageprovider.ResourcePermissions) string {
+	if p != nil && p.InitiateFileUpload {
+		return roleWrite
+	}
+	return roleRead
+}
+
+// recipient is a user or group resolved from the directory.
+type recipient struct {
+	grantee  *storageprovider.Grantee
+	identity *msgraph.Identity
+	email    string
+}
+
+// resolveRecipient looks up a drive recipient in the users and groups of
+// the directory, either by its object id or by its email address. It
+// returns errEntryNotFound if neither matches.
+func (g Graph) resolveRecipient(conn *ldap.Conn, dr msgraph.DriveRecipient) (*recipient, error) {
+	var filter string
+	switch {
+	case dr.ObjectID != nil:
+		filter = fmt.Sprintf("(entryuuid=%s)", ldap.EscapeFilter(*dr.ObjectID))
+	case dr.Email != nil:
+		filter = fmt.Sprintf("(mail=%s)", ldap.EscapeFilter(*dr.Email))
+	default:
+		return nil, errInvalidReference
+	}
+
+	user, err := g.ldapSearchSingleEntry(conn, g.config.Ldap.BaseDNUsers, filter)
+	switch {
+	case err == nil:
+		id := user.GetAttributeValue("entryuuid")
+		displayName := user.GetAttributeValue("displayname")
+		return &recipient{
+			grantee: &storageprovider.Grantee{
+				Type: storageprovider.GranteeType_GRANTEE_TYPE_USER,
+				Id: &userpb.UserId{
+					Idp:      g.config.OpenIDConnect.Endpoint,
+					OpaqueId: id,
+				},
+			},
+			identity: &msgraph.Identity{
+				ID:          &id,
+				DisplayName: &displayName,
+			},
+			email: user.GetAttributeValue("mail"),
+		}, nil
+	case err != errEntryNotFound:
+		return nil, err
+	}
+
+	group, err := g.ldapSearchSingleEntry(conn, g.config.Ldap.BaseDNGroups, filter)
+	if err != nil {
+		return nil, err
+	}
+	id := group.GetAttributeValue("entryuuid")
+	displayName := group.GetAttributeValue("cn")
+	return &recipient{
+		grantee: &storageprovider.Grantee{
+			Type: storageprovider.GranteeType_GRANTEE_TYPE_GROUP,
+			// CS3 identifies groups by name
+			Id: &userpb.UserId{
+				OpaqueId: displayName,
+			},
+		},
+		identity: &msgraph.Identity{
+			ID:          &id,
+			DisplayName: &displayName,
+		},
+		email: group.GetAttributeValue("mail"),
+	}, nil
+}
+
+// cs3ShareToPermission converts a CS3 share into a permission granted to
+// the identity of the grantee.
+func cs3ShareToPermission(share *collaboration.Share, grantee *msgraph.Identity) *msgraph.Permission {
+	id := sharePermissionPrefix + share.Id.OpaqueId
+	var permissions *storageprovider.ResourcePermissions
+	if share.Permissions != nil {
+		permissions = share.Permissions.Permissions
+	}
+	return &msgraph.Permission{
+		Entity: msgraph.Entity{
+			ID: &id,
+		},
+		GrantedTo: &msgraph.IdentitySet{
+			User: grantee,
+		},
+		Roles: []string{permissionsRole(permissions)},
+	}
+}
+
+// InviteDriveItem shares the item resolved by DriveItemCtx with users and
+// groups of the directory, see https://docs.microsoft.com/en-us/graph/api/driveitem-invite?view=graph-rest-1.0
+func (g Graph) InviteDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := struct {
+		Recipients []msgraph.DriveRecipient `json:"recipients"`
+		Roles      []string                 `json:"roles"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode invite request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if len(req.Recipients) == 0 || len(req.Roles) == 0 {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// the most permissive role wins
+	role := roleRead
+	for _, rl := range req.Roles {
+		if _, err := rolePermissions(rl); err != nil {
+			g.logger.Debug().Str("role", rl).Msg("unknown sharing role")
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+		if rl == roleWrite {
+			role = roleWrite
+		}
+	}
+	permissions, _ := rolePermissions(role)
+
+	conn, err := g.initLdap()
+	if err != nil {
+		g.logger.Error().Err(err).Msg("Failed to initialize ldap")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusServiceUnavailable)
+		return
+	}
+	defer conn.Close()
+
+	recipients := make([]*recipient, 0, len(req.Recipients))
+	for _, dr := range req.Recipients {
+		rcpt, err := g.resolveRecipient(conn, dr)
+		switch {
+		case err == errEntryNotFound || err == errInvalidReference:
+			g.logger.Debug().Err(err).Msg("could not resolve recipient")
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		case err != nil:
+			g.logger.Error().Err(err).Msg("error searching ldap for recipient")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusServiceUnavailable)
+			return
+		}
+		recipients = append(recipients, rcpt)
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	if statRes.Info.Path == root.Path {
+		errorcode.NotAllowed.Render(w, r, http.StatusForbidden)
+		return
+	}
+
+	// the invitation succeeds for all recipients or none of them
+	shares := make([]*collaboration.Share, 0, len(recipients))
+	granted := make([]*msgraph.Permission, 0, len(recipients))
+	for _, rcpt := range recipients {
+		res, err := client.CreateShare(ctx, &collaboration.CreateShareRequest{
+			ResourceInfo: statRes.Info,
+			Grant: &collaboration.ShareGrant{
+				Grantee: rcpt.grantee,
+				Permissions: &collaboration.SharePermissions{
+					Permissions: permissions,
+				},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending create share grpc request %s", statRes.Info.Path)
+			g.removeShares(ctx, client, shares)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc create share %s", statRes.Info.Path)
+			g.removeShares(ctx, client, shares)
+			renderStatus(w, r, res.Status)
+			return
+		}
+		shares = append(shares, res.Share)
+
+		permission := cs3ShareToPermission(res.Share, rcpt.identity)
+		if rcpt.email != "" {
+			signInRequired := true
+			permission.Invitation = &msgraph.SharingInvitation{
+				Email:          &rcpt.email,
+				SignInRequired: &signInRequired,
+			}
+		}
+		granted = append(granted, permission)
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: granted})
+}
+
+// removeShares rolls back the shares created by a failed invitation. Errors
+// are only logged, the invitation already failed.
+func (g Graph) removeShares(ctx context.Context, client gateway.GatewayAPIClient, shares []*collaboration.Share) {
+	for _, share := range shares {
+		res, err := client.RemoveShare(ctx, &collaboration.RemoveShareRequest{
+			Ref: &collaboration.ShareReference{
+				Spec: &collaboration.ShareReference_Id{Id: share.Id},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending remove share grpc request %s", share.Id.OpaqueId)
+			continue
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Error().Str("code", res.Status.Code.String()).Msgf("error rolling back share %s", share.Id.OpaqueId)
+		}
+	}
+}
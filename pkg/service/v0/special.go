@@ -0,0 +1,152 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// errNotAFolder is returned if a file is in the place of a special folder.
+var errNotAFolder = errors.New("not a folder")
+
+// specialFolderPath returns the configured path of a special folder
+// relative to the home, see https://docs.microsoft.com/en-us/graph/api/drive-get-specialfolder?view=graph-rest-1.0#special-folder-names
+func (g Graph) specialFolderPath(name string) (string, bool) {
+	var p string
+	switch name {
+	case "documents":
+		p = g.config.SpecialFolders.Documents
+	case "photos":
+		p = g.config.SpecialFolders.Photos
+	case "approot":
+		p = g.config.SpecialFolders.AppRoot
+	}
+	p = strings.Trim(path.Clean("/"+p), "/")
+	return p, p != ""
+}
+
+// ensureFolder creates the folder rel below base and all its missing
+// parents. It returns the path of the folder.
+func ensureFolder(ctx context.Context, client gateway.GatewayAPIClient, base string, rel string) (string, error) {
+	fn := base
+	for _, name := range strings.Split(rel, "/") {
+		fn = path.Join(fn, name)
+		ref := &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: fn},
+		}
+		statRes, err := client.Stat(ctx, &storageprovider.StatRequest{Ref: ref})
+		if err != nil {
+			return "", err
+		}
+		switch statRes.Status.Code {
+		case cs3rpc.Code_CODE_OK:
+			if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+				return "", errNotAFolder
+			}
+			continue
+		case cs3rpc.Code_CODE_NOT_FOUND:
+		default:
+			return "", &statusError{status: statRes.Status}
+		}
+
+		createRes, err := client.CreateContainer(ctx, &storageprovider.CreateContainerRequest{Ref: ref})
+		if err != nil {
+			return "", err
+		}
+		if createRes.Status.Code != cs3rpc.Code_CODE_OK {
+			return "", &statusError{status: createRes.Status}
+		}
+	}
+	return fn, nil
+}
+
+// SpecialFolderCtx middleware is used to resolve a special folder of the
+// home from the URL parameters passed through as the request. Missing
+// special folders are created. In case the name is unknown, we stop here
+// and return a 404.
+func (g Graph) SpecialFolderCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+
+		name := strings.ToLower(chi.URLParam(r, "specialFolderName"))
+		rel, ok := g.specialFolderPath(name)
+		if !ok {
+			g.logger.Info().Msgf("Unknown special folder %s", name)
+			errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+			return
+		}
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		fn, err := ensureFolder(ctx, client, root.Path, rel)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error creating special folder %s", name)
+			renderError(w, r, err)
+			return
+		}
+
+		ctx = context.WithValue(ctx, specialFolderKey, name)
+		ctx = context.WithValue(ctx, driveItemKey, &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: fn},
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetSpecialFolder returns the special folder resolved by SpecialFolderCtx,
+// see https://docs.microsoft.com/en-us/graph/api/drive-get-specialfolder?view=graph-rest-1.0
+func (g Graph) GetSpecialFolder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+	name := ctx.Value(specialFolderKey).(string)
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(res.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	item.SpecialFolder = &msgraph.SpecialFolder{Name: &name}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, item)
+}
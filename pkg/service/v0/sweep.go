@@ -0,0 +1,66 @@
+package svc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sweepInterval defines how often expired state is removed from the store.
+const sweepInterval = time.Hour
+
+// newExpiringToken returns a random token with the expiration encoded, so
+// expired entries keyed by it can be swept without reading them.
+func newExpiringToken(expiration time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(expiration.Unix(), 10) + "-" + hex.EncodeToString(id), nil
+}
+
+// expiringTokenExpired checks the expiration encoded in a token returned
+// by newExpiringToken.
+func expiringTokenExpired(token string) bool {
+	i := strings.Index(token, "-")
+	if i < 0 {
+		return true
+	}
+	exp, err := strconv.ParseInt(token[:i], 10, 64)
+	return err != nil || time.Now().Unix() > exp
+}
+
+// sweepStore removes the entries below prefix from the store that expired
+// checks as expired.
+func (g Graph) sweepStore(prefix string, expired func(key string) bool) {
+	keys, err := g.store.List(prefix)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing %s", prefix)
+		return
+	}
+	for _, key := range keys {
+		if !expired(key) {
+			continue
+		}
+		if err := g.store.Delete(key); err != nil {
+			g.logger.Error().Err(err).Msgf("error deleting %s", key)
+		}
+	}
+}
+
+// sweep periodically removes expired state from the store. Expired entries
+// are also rejected when they are read, sweeping keeps the store from
+// growing with entries that are never read again.
+func (g Graph) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		g.sweepStore(deltaStorePrefix, deltaSnapshotExpired)
+		g.sweepStore(searchStorePrefix, searchPositionExpired)
+		g.sweepStore(uploadSessionStorePrefix, g.uploadSessionExpired)
+		g.sweepStore(thumbnailStorePrefix, g.thumbnailExpired)
+	}
+}
@@ -0,0 +1,266 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	// register the decoder of the generated thumbnails
+	_ "image/jpeg"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	"github.com/owncloud/ocis-graph/pkg/thumbnail"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// thumbnailSizes maps the MS Graph thumbnail sizes to the length of the
+// longest edge in pixels.
+var thumbnailSizes = map[string]int{
+	"small":  96,
+	"medium": 176,
+	"large":  800,
+}
+
+// thumbnailSetID is the id of the only thumbnail set of an item.
+const thumbnailSetID = "0"
+
+// thumbnailStorePrefix is the prefix of the store keys of thumbnails.
+const thumbnailStorePrefix = "thumbnails/"
+
+// thumbnailTTL defines how long a thumbnail is cached. Thumbnails of
+// changed files get new keys, the old ones are removed when they expire.
+const thumbnailTTL = 7 * 24 * time.Hour
+
+// thumbnailStoreKey contains the etag, so changed files get new thumbnails.
+func thumbnailStoreKey(info *storageprovider.ResourceInfo, size string) string {
+	return thumbnailStorePrefix + wrapResourceID(info.Id) + "/" + strings.Trim(info.Etag, `"`) + "/" + size
+}
+
+// readThumbnail reads a cached thumbnail. It is stored after the unix time
+// it expires at, see writeThumbnail.
+func (g Graph) readThumbnail(key string) ([]byte, time.Time, error) {
+	b, err := g.store.Read(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(b) < 8 {
+		return nil, time.Time{}, store.ErrNotFound
+	}
+	return b[8:], time.Unix(int64(binary.BigEndian.Uint64(b)), 0), nil
+}
+
+// writeThumbnail caches a thumbnail for thumbnailTTL. Thumbnails are only
+// cached in the file store, the memory store would keep every thumbnail
+// requested within thumbnailTTL in memory.
+func (g Graph) writeThumbnail(key string, thumb []byte) error {
+	if g.config.Store.Type != "file" {
+		return nil
+	}
+	b := make([]byte, 8, 8+len(thumb))
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Add(thumbnailTTL).Unix()))
+	return g.store.Write(key, append(b, thumb...))
+}
+
+// thumbnailExpired checks if the thumbnail stored at key expired.
+func (g Graph) thumbnailExpired(key string) bool {
+	_, exp, err := g.readThumbnail(key)
+	return err != nil || time.Now().After(exp)
+}
+
+// getThumbnail returns the thumbnail of a file from the store or generates
+// it. At most thumbnailSlots thumbnails are generated at the same time.
+func (g Graph) getThumbnail(ctx context.Context, client gateway.GatewayAPIClient, info *storageprovider.ResourceInfo, size string) ([]byte, error) {
+	key := thumbnailStoreKey(info, size)
+	b, exp, err := g.readThumbnail(key)
+	if err == nil && time.Now().Before(exp) {
+		return b, nil
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	select {
+	case g.thumbnailSlots <- struct{}{}:
+		defer func() { <-g.thumbnailSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	res, err := downloadFile(ctx, g.dataClient, client, &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Id{Id: info.Id},
+	}, "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected data gateway response %d downloading %s", res.StatusCode, info.Path)
+	}
+
+	if b, err = thumbnail.Generate(res.Body, thumbnailSizes[size]); err != nil {
+		return nil, err
+	}
+	if err := g.writeThumbnail(key, b); err != nil {
+		g.logger.Error().Err(err).Msgf("error caching thumbnail %s", key)
+	}
+	return b, nil
+}
+
+// statThumbnailSource stats the item resolved by DriveItemCtx. It renders
+// the error response if that fails.
+func (g Graph) statThumbnailSource(w http.ResponseWriter, r *http.Request, client gateway.GatewayAPIClient) (*storageprovider.ResourceInfo, bool) {
+	ref := r.Context().Value(driveItemKey).(*storageprovider.Reference)
+
+	statRes, err := client.Stat(r.Context(), &storageprovider.StatRequest{Ref: ref})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return nil, false
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return nil, false
+	}
+	return statRes.Info, true
+}
+
+// GetThumbnailSets lists the thumbnails of the item resolved by DriveItemCtx.
+// Only images have thumbnails, the list is empty for other items,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-list-thumbnails?view=graph-rest-1.0
+func (g Graph) GetThumbnailSets(w http.ResponseWriter, r *http.Request) {
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	info, ok := g.statThumbnailSource(w, r, client)
+	if !ok {
+		return
+	}
+
+	sets := []*msgraph.ThumbnailSet{}
+	if info.Type == storageprovider.ResourceType_RESOURCE_TYPE_FILE && thumbnail.Supported(info.MimeType) {
+		base := g.requestPath(r)
+		thumbnailURL := func(size string) *msgraph.Thumbnail {
+			u := g.absoluteURL(r, path.Join(base, thumbnailSetID, size, "content"))
+			return &msgraph.Thumbnail{URL: &u}
+		}
+		id := thumbnailSetID
+		sets = append(sets, &msgraph.ThumbnailSet{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+			Small:  thumbnailURL("small"),
+			Medium: thumbnailURL("medium"),
+			Large:  thumbnailURL("large"),
+		})
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: sets})
+}
+
+// thumbnailParams validates the set id and size URL parameters.
+func thumbnailParams(r *http.Request) (string, bool) {
+	size := chi.URLParam(r, "size")
+	if chi.URLParam(r, "setID") != thumbnailSetID {
+		return "", false
+	}
+	_, ok := thumbnailSizes[size]
+	return size, ok
+}
+
+// loadThumbnail loads the thumbnail requested by the URL parameters and
+// renders the error response if that fails.
+func (g Graph) loadThumbnail(w http.ResponseWriter, r *http.Request) ([]byte, *storageprovider.ResourceInfo, bool) {
+	size, ok := thumbnailParams(r)
+	if !ok {
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	info, ok := g.statThumbnailSource(w, r, client)
+	if !ok {
+		return nil, nil, false
+	}
+	if info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE || !thumbnail.Supported(info.MimeType) {
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	b, err := g.getThumbnail(r.Context(), client, info, size)
+	switch {
+	case err == nil:
+		return b, info, true
+	case err == thumbnail.ErrTooLarge:
+		g.logger.Debug().Msgf("image too large for a thumbnail %s", info.Path)
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+	default:
+		g.logger.Error().Err(err).Msgf("error generating thumbnail %s", info.Path)
+		renderError(w, r, err)
+	}
+	return nil, nil, false
+}
+
+// GetThumbnail returns the size of a thumbnail.
+func (g Graph) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	b, _, ok := g.loadThumbnail(w, r)
+	if !ok {
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		g.logger.Error().Err(err).Msg("error decoding thumbnail")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	u := g.absoluteURL(r, path.Join(g.requestPath(r), "content"))
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &msgraph.Thumbnail{
+		Width:  &cfg.Width,
+		Height: &cfg.Height,
+		URL:    &u,
+	})
+}
+
+// GetThumbnailContent streams a thumbnail, see
+// https://docs.microsoft.com/en-us/graph/api/driveitem-list-thumbnails?view=graph-rest-1.0#get-a-single-thumbnail
+func (g Graph) GetThumbnailContent(w http.ResponseWriter, r *http.Request) {
+	b, info, ok := g.loadThumbnail(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("ETag", info.Etag)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		g.logger.Error().Err(err).Msg("error writing thumbnail")
+	}
+}
@@ -0,0 +1,263 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// etagMatches checks the value of an If-Match header against an etag.
+func etagMatches(ifMatch, etag string) bool {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(strings.TrimPrefix(candidate, "W/"), `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveParentPath returns the path of the folder an item reference
+// points to, either by id or by a path relative to the drive root. Items
+// can not be moved or copied to other drives, a drive id other than the one
+// of root is an invalid reference.
+func resolveParentPath(ctx context.Context, client gateway.GatewayAPIClient, root *storageprovider.ResourceInfo, pr *msgraph.ItemReference) (string, error) {
+	if pr.DriveID != nil && *pr.DriveID != wrapResourceID(root.Id) {
+		return "", errInvalidReference
+	}
+	switch {
+	case pr.ID != nil:
+		id := unwrapResourceID(*pr.ID)
+		if id == nil {
+			return "", errInvalidReference
+		}
+		res, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Id{Id: id},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		if res.Status.Code != cs3rpc.Code_CODE_OK {
+			return "", &statusError{status: res.Status}
+		}
+		if res.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER {
+			return "", errInvalidReference
+		}
+		return res.Info.Path, nil
+	case pr.Path != nil:
+		i := strings.Index(*pr.Path, "root:")
+		if i < 0 {
+			return "", errInvalidReference
+		}
+		return path.Join(root.Path, path.Clean("/"+(*pr.Path)[i+len("root:"):])), nil
+	default:
+		return "", errInvalidReference
+	}
+}
+
+// UpdateDriveItem renames and moves the item resolved by DriveItemCtx. An
+// existing item at the destination is handled according to the
+// @microsoft.graph.conflictBehavior query parameter, failing by default,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-move?view=graph-rest-1.0
+func (g Graph) UpdateDriveItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := struct {
+		Name            string                 `json:"name"`
+		ParentReference *msgraph.ItemReference `json:"parentReference"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		g.logger.Debug().Err(err).Msg("could not decode update drive item request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(req.Name, "/") || req.Name == "." || req.Name == ".." {
+		g.logger.Debug().Str("name", req.Name).Msg("invalid drive item name")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	cb, err := parseConflictBehavior(r.URL.Query().Get("@microsoft.graph.conflictBehavior"), conflictBehaviorFail)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("invalid conflict behavior")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+	src := statRes.Info.Path
+	if src == root.Path {
+		errorcode.NotAllowed.Render(w, r, http.StatusForbidden)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, statRes.Info.Etag) {
+		errorcode.ResourceModified.Render(w, r, http.StatusPreconditionFailed)
+		return
+	}
+	if err := checkTreeLock(ctx, client, statRes.Info); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking checkout of %s", statRes.Info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	dir, name := path.Split(src)
+	if req.Name != "" {
+		name = req.Name
+	}
+	if req.ParentReference != nil {
+		if dir, err = resolveParentPath(ctx, client, root, req.ParentReference); err != nil {
+			g.logger.Debug().Err(err).Msg("could not resolve parent reference")
+			renderError(w, r, err)
+			return
+		}
+	}
+
+	dst := path.Join(dir, name)
+	if dst != src {
+		isFolder := statRes.Info.Type == storageprovider.ResourceType_RESOURCE_TYPE_CONTAINER
+		if isFolder && strings.HasPrefix(dst, src+"/") {
+			g.logger.Debug().Msgf("can not move %s into itself", src)
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(src, dst+"/") {
+			// replacing a parent would delete the item itself
+			g.logger.Debug().Msgf("can not move %s to its parent %s", src, dst)
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+
+		dstRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+			Ref: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: dst},
+			},
+			ArbitraryMetadataKeys: driveItemMetadataKeys,
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", dst)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		switch dstRes.Status.Code {
+		case cs3rpc.Code_CODE_OK:
+			switch cb {
+			case conflictBehaviorFail:
+				errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+				return
+			case conflictBehaviorRename:
+				available, err := findAvailablePath(ctx, client, dst, !isFolder)
+				if err != nil {
+					g.logger.Error().Err(err).Msgf("error finding available name for %s", dst)
+					errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+					return
+				}
+				dst = available
+			case conflictBehaviorReplace:
+				if err := checkTreeLock(ctx, client, dstRes.Info); err != nil {
+					g.logger.Debug().Err(err).Msgf("error checking checkout of %s", dst)
+					renderError(w, r, err)
+					return
+				}
+				delRes, err := client.Delete(ctx, &storageprovider.DeleteRequest{
+					Ref: &storageprovider.Reference{
+						Spec: &storageprovider.Reference_Path{Path: dst},
+					},
+				})
+				if err != nil {
+					g.logger.Error().Err(err).Msgf("error sending delete grpc request %s", dst)
+					errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+					return
+				}
+				if delRes.Status.Code != cs3rpc.Code_CODE_OK {
+					g.logger.Debug().Str("code", delRes.Status.Code.String()).Msgf("error calling grpc delete %s", dst)
+					renderStatus(w, r, delRes.Status)
+					return
+				}
+			}
+		case cs3rpc.Code_CODE_NOT_FOUND:
+		default:
+			g.logger.Debug().Str("code", dstRes.Status.Code.String()).Msgf("error calling grpc stat %s", dst)
+			renderStatus(w, r, dstRes.Status)
+			return
+		}
+
+		moveRes, err := client.Move(ctx, &storageprovider.MoveRequest{
+			Source: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: src},
+			},
+			Destination: &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: dst},
+			},
+		})
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error sending move grpc request %s to %s", src, dst)
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		if moveRes.Status.Code != cs3rpc.Code_CODE_OK {
+			g.logger.Debug().Str("code", moveRes.Status.Code.String()).Msgf("error calling grpc move %s to %s", src, dst)
+			renderStatus(w, r, moveRes.Status)
+			return
+		}
+	}
+
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: dst},
+		},
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", dst)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", dst)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(res.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, item)
+}
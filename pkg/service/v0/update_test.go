@@ -0,0 +1,76 @@
+package svc
+
+import (
+	"context"
+	"testing"
+
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		etag    string
+		want    bool
+	}{
+		{"equal", `"abc"`, `"abc"`, true},
+		{"unquoted etag", `"abc"`, `abc`, true},
+		{"unquoted header", `abc`, `"abc"`, true},
+		{"weak header", `W/"abc"`, `"abc"`, true},
+		{"weak etag", `"abc"`, `W/"abc"`, true},
+		{"any", `*`, `"abc"`, true},
+		{"list", `"xyz", "abc"`, `"abc"`, true},
+		{"list with spaces", ` "xyz" ,  "abc" `, `"abc"`, true},
+		{"different", `"xyz"`, `"abc"`, false},
+		{"prefix", `"ab"`, `"abc"`, false},
+		{"empty", ``, `"abc"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifMatch, tt.etag); got != tt.want {
+				t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifMatch, tt.etag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveParentPath(t *testing.T) {
+	root := &storageprovider.ResourceInfo{
+		Id:   &storageprovider.ResourceId{StorageId: "storage", OpaqueId: "home"},
+		Path: "/home",
+	}
+	driveID := wrapResourceID(root.Id)
+	otherDrive := wrapResourceID(&storageprovider.ResourceId{StorageId: "storage", OpaqueId: "project"})
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		ref     *msgraph.ItemReference
+		want    string
+		wantErr bool
+	}{
+		{name: "root", ref: &msgraph.ItemReference{Path: str("/drive/root:")}, want: "/home"},
+		{name: "folder", ref: &msgraph.ItemReference{Path: str("/drive/root:/a/b")}, want: "/home/a/b"},
+		{name: "dot segments", ref: &msgraph.ItemReference{Path: str("/drive/root:/a/../../..")}, want: "/home"},
+		{name: "same drive", ref: &msgraph.ItemReference{DriveID: &driveID, Path: str("/drives/x/root:/a")}, want: "/home/a"},
+		{name: "other drive", ref: &msgraph.ItemReference{DriveID: &otherDrive, Path: str("/drives/x/root:/a")}, wantErr: true},
+		{name: "other drive by id", ref: &msgraph.ItemReference{DriveID: &otherDrive, ID: str("x")}, wantErr: true},
+		{name: "no root", ref: &msgraph.ItemReference{Path: str("/drive/a")}, wantErr: true},
+		{name: "empty", ref: &msgraph.ItemReference{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveParentPath(context.Background(), nil, root, tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveParentPath() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveParentPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+)
+
+// uploadFile writes length bytes from body to the file at ref using the
+// InitiateFileUpload and data gateway PUT flow.
+func uploadFile(ctx context.Context, httpClient *http.Client, client gateway.GatewayAPIClient, ref *storageprovider.Reference, length int64, body io.Reader) error {
+	uReq := &storageprovider.InitiateFileUploadRequest{
+		Ref: ref,
+		Opaque: &typespb.Opaque{
+			Map: map[string]*typespb.OpaqueEntry{
+				"Upload-Length": {
+					Decoder: "plain",
+					Value:   []byte(strconv.FormatInt(length, 10)),
+				},
+			},
+		},
+	}
+	uRes, err := client.InitiateFileUpload(ctx, uReq)
+	if err != nil {
+		return err
+	}
+	if uRes.Status.Code != cs3rpc.Code_CODE_OK {
+		return &statusError{status: uRes.Status}
+	}
+
+	req, err := newDataRequest(ctx, http.MethodPut, uRes.UploadEndpoint, uRes.Token, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected data gateway response %d", res.StatusCode)
+	}
+	return nil
+}
+
+// PutDriveItemContent creates or replaces the file resolved by DriveItemCtx
+// with the request body, see https://docs.microsoft.com/en-us/graph/api/driveitem-put-content?view=graph-rest-1.0
+func (g Graph) PutDriveItemContent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	cb, err := parseConflictBehavior(r.URL.Query().Get("@microsoft.graph.conflictBehavior"), conflictBehaviorReplace)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("invalid conflict behavior")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength < 0 {
+		errorcode.InvalidRequest.Render(w, r, http.StatusLengthRequired)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	var replaced uint64
+	switch statRes.Status.Code {
+	case cs3rpc.Code_CODE_OK:
+		if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		}
+		switch cb {
+		case conflictBehaviorFail:
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		case conflictBehaviorRename:
+			fn, err := findAvailablePath(ctx, client, statRes.Info.Path, true)
+			if err != nil {
+				g.logger.Error().Err(err).Msgf("error finding available name for %s", statRes.Info.Path)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: fn},
+			}
+		default:
+			if err := checkLock(ctx, statRes.Info); err != nil {
+				g.logger.Debug().Err(err).Msgf("error checking checkout of %s", statRes.Info.Path)
+				renderError(w, r, err)
+				return
+			}
+			status = http.StatusOK
+			replaced = statRes.Info.Size
+			ref = &storageprovider.Reference{
+				Spec: &storageprovider.Reference_Path{Path: statRes.Info.Path},
+			}
+		}
+	case cs3rpc.Code_CODE_NOT_FOUND:
+		if ref.GetPath() == "" {
+			// an item addressed by id has to exist
+			renderStatus(w, r, statRes.Status)
+			return
+		}
+	default:
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+
+	if err := checkQuota(ctx, client, root, replaced, r.ContentLength); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking quota for %s", ref)
+		renderError(w, r, err)
+		return
+	}
+
+	if err := uploadFile(ctx, g.dataClient, client, ref, r.ContentLength, r.Body); err != nil {
+		g.logger.Error().Err(err).Msgf("error uploading file %s", ref)
+		renderError(w, r, err)
+		return
+	}
+
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(res.Info, root)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, status)
+	render.JSON(w, r, item)
+}
@@ -0,0 +1,553 @@
+package svc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	userv1beta1 "github.com/cs3org/go-cs3apis/cs3/identity/user/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	typespb "github.com/cs3org/go-cs3apis/cs3/types/v1beta1"
+	"github.com/cs3org/reva/pkg/token"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	"github.com/owncloud/ocis-graph/pkg/store"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// uploadSessionTTL defines how long an upload session can be resumed at
+// most. Sessions expire earlier with the reva token of the user or the
+// transfer token of the upload, whichever expires first.
+const uploadSessionTTL = 24 * time.Hour
+
+// uploadSessionStorePrefix is the prefix of the store keys of upload sessions.
+const uploadSessionStorePrefix = "uploadsessions/"
+
+// uploadSession holds the state of a resumable upload. The upload is
+// initiated when the session is created, the fragments are forwarded to the
+// TUS endpoint that reva returns from InitiateFileUpload. Fragments are sent
+// without an access token, so the session keeps the reva token of the user
+// that created it to check the file on their behalf. The session expires
+// with that token.
+type uploadSession struct {
+	ID         string              `json:"id"`
+	User       *userv1beta1.UserId `json:"user"`
+	RevaToken  string              `json:"reva_token"`
+	Path       string              `json:"path"`
+	RootPath   string              `json:"root_path"`
+	RootID     string              `json:"root_id"`
+	Replace    bool                `json:"replace"`
+	Size       int64               `json:"size"`
+	Offset     int64               `json:"offset"`
+	Endpoint   string              `json:"endpoint"`
+	Transfer   string              `json:"transfer"`
+	Expiration time.Time           `json:"expiration"`
+}
+
+// tokenExpiration returns the expiration of a JWT, e.g. a reva or transfer
+// token. The signature is not verified, reva does that when the token is
+// used. Tokens that can not be read return the zero time.
+func tokenExpiration(t string) time.Time {
+	parts := strings.Split(t, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	claims := struct {
+		ExpiresAt int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(b, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0).UTC()
+}
+
+// expireWith makes the session expire no later than the token t.
+func (s *uploadSession) expireWith(t string) {
+	if exp := tokenExpiration(t); !exp.IsZero() && exp.Before(s.Expiration) {
+		s.Expiration = exp
+	}
+}
+
+func uploadSessionStoreKey(id string) string {
+	return uploadSessionStorePrefix + id
+}
+
+// uploadSessionExpired checks if the session stored at key expired. Sessions
+// that can not be read are expired as well.
+func (g Graph) uploadSessionExpired(key string) bool {
+	s, err := g.readUploadSession(strings.TrimPrefix(key, uploadSessionStorePrefix))
+	return err != nil || time.Now().After(s.Expiration)
+}
+
+func (g Graph) readUploadSession(id string) (*uploadSession, error) {
+	b, err := g.store.Read(uploadSessionStoreKey(id))
+	if err != nil {
+		return nil, err
+	}
+	s := &uploadSession{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (g Graph) writeUploadSession(s *uploadSession) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return g.store.Write(uploadSessionStoreKey(s.ID), b)
+}
+
+// toGraph renders the session as MS Graph upload session.
+func (s *uploadSession) toGraph(uploadURL string) *msgraph.UploadSession {
+	return &msgraph.UploadSession{
+		UploadURL:          &uploadURL,
+		ExpirationDateTime: &s.Expiration,
+		NextExpectedRanges: []string{fmt.Sprintf("%d-", s.Offset)},
+	}
+}
+
+// root returns the root of the drive the file is uploaded to.
+func (s *uploadSession) root() *storageprovider.ResourceInfo {
+	return &storageprovider.ResourceInfo{
+		Id:   unwrapResourceID(s.RootID),
+		Path: s.RootPath,
+	}
+}
+
+// parseContentRange parses a Content-Range header of an upload fragment,
+// e.g. bytes 0-25/128.
+func parseContentRange(s string) (start, end, total int64, err error) {
+	const b = "bytes "
+	if !strings.HasPrefix(s, b) {
+		return 0, 0, 0, errInvalidRange
+	}
+	spec := strings.TrimPrefix(s, b)
+	i, j := strings.Index(spec, "-"), strings.Index(spec, "/")
+	if i < 0 || j < i {
+		return 0, 0, 0, errInvalidRange
+	}
+	if start, err = strconv.ParseInt(spec[:i], 10, 64); err != nil {
+		return 0, 0, 0, errInvalidRange
+	}
+	if end, err = strconv.ParseInt(spec[i+1:j], 10, 64); err != nil {
+		return 0, 0, 0, errInvalidRange
+	}
+	if total, err = strconv.ParseInt(spec[j+1:], 10, 64); err != nil {
+		return 0, 0, 0, errInvalidRange
+	}
+	if start < 0 || end < start || end >= total {
+		return 0, 0, 0, errInvalidRange
+	}
+	return start, end, total, nil
+}
+
+// CreateUploadSession creates a resumable upload session for the item resolved by DriveItemCtx,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-createuploadsession?view=graph-rest-1.0
+func (g Graph) CreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	root := ctx.Value(driveRootKey).(*storageprovider.ResourceInfo)
+	ref := ctx.Value(driveItemKey).(*storageprovider.Reference)
+
+	req := struct {
+		Item struct {
+			ConflictBehavior string `json:"@microsoft.graph.conflictBehavior"`
+			FileSize         int64  `json:"fileSize"`
+		} `json:"item"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		g.logger.Debug().Err(err).Msg("could not decode create upload session request")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	cb, err := parseConflictBehavior(req.Item.ConflictBehavior, conflictBehaviorReplace)
+	if err != nil {
+		g.logger.Debug().Err(err).Msg("invalid conflict behavior")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if req.Item.FileSize <= 0 {
+		// the upload is initiated now, TUS needs to know its length up front
+		g.logger.Debug().Msg("missing file size")
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	statRes, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	revaToken, _ := token.ContextGetToken(ctx)
+	s := &uploadSession{
+		RevaToken:  revaToken,
+		Path:       ref.GetPath(),
+		RootPath:   root.Path,
+		RootID:     wrapResourceID(root.Id),
+		Size:       req.Item.FileSize,
+		Expiration: time.Now().Add(uploadSessionTTL).UTC(),
+	}
+	if u := revaUser(ctx); u != nil {
+		s.User = u.Id
+	}
+	s.expireWith(revaToken)
+	var replaced uint64
+	switch statRes.Status.Code {
+	case cs3rpc.Code_CODE_OK:
+		if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		}
+		switch cb {
+		case conflictBehaviorFail:
+			errorcode.NameAlreadyExists.Render(w, r, http.StatusConflict)
+			return
+		case conflictBehaviorRename:
+			if s.Path, err = findAvailablePath(ctx, client, statRes.Info.Path, true); err != nil {
+				g.logger.Error().Err(err).Msgf("error finding available name for %s", statRes.Info.Path)
+				errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+				return
+			}
+		default:
+			if err := checkLock(ctx, statRes.Info); err != nil {
+				g.logger.Debug().Err(err).Msgf("error checking checkout of %s", statRes.Info.Path)
+				renderError(w, r, err)
+				return
+			}
+			s.Path = statRes.Info.Path
+			s.Replace = true
+			replaced = statRes.Info.Size
+		}
+	case cs3rpc.Code_CODE_NOT_FOUND:
+		if s.Path == "" {
+			// an item addressed by id has to exist
+			renderStatus(w, r, statRes.Status)
+			return
+		}
+	default:
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return
+	}
+
+	if err := checkQuota(ctx, client, root, replaced, s.Size); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking quota for %s", s.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	uRes, err := client.InitiateFileUpload(ctx, &storageprovider.InitiateFileUploadRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: s.Path},
+		},
+		Opaque: &typespb.Opaque{
+			Map: map[string]*typespb.OpaqueEntry{
+				"Upload-Length": {
+					Decoder: "plain",
+					Value:   []byte(strconv.FormatInt(s.Size, 10)),
+				},
+			},
+		},
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending initiate file upload grpc request %s", s.Path)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if uRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", uRes.Status.Code.String()).Msgf("error calling grpc initiate file upload %s", s.Path)
+		renderStatus(w, r, uRes.Status)
+		return
+	}
+	// a new upload starts at offset 0 and transfer tokens can not be
+	// renewed, so the session can not outlive the token
+	s.Endpoint, s.Transfer = uRes.UploadEndpoint, uRes.Token
+	s.expireWith(uRes.Token)
+
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		g.logger.Error().Err(err).Msg("error generating upload session id")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	s.ID = hex.EncodeToString(id)
+
+	if err := g.writeUploadSession(s); err != nil {
+		g.logger.Error().Err(err).Msg("error storing upload session")
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, s.toGraph(g.absoluteURL(r, "/uploadSessions/"+s.ID)))
+}
+
+// UploadSessionCtx middleware is used to load an upload session from the
+// URL parameters passed through as the request. The session id acts as
+// credential, so no access token is needed. Like RevaCtx it stores the reva
+// token and user of the session in the request context. In case the session
+// could not be found or expired, we stop here and return a 404.
+func (g Graph) UploadSessionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := chi.URLParam(r, "sessionID")
+		s, err := g.readUploadSession(sessionID)
+		if err != nil {
+			g.logger.Info().Err(err).Msgf("Failed to read upload session %s", sessionID)
+			errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+			return
+		}
+		if time.Now().After(s.Expiration) {
+			g.logger.Info().Msgf("Upload session %s expired", sessionID)
+			if err := g.store.Delete(uploadSessionStoreKey(s.ID)); err != nil {
+				g.logger.Error().Err(err).Msgf("error deleting upload session %s", s.ID)
+			}
+			errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), uploadSessionKey, s)
+		ctx = context.WithValue(ctx, revaUserKey, &userv1beta1.User{Id: s.User})
+		next.ServeHTTP(w, r.WithContext(withRevaToken(ctx, s.RevaToken)))
+	})
+}
+
+// GetUploadSession returns the status of an upload session.
+func (g Graph) GetUploadSession(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(uploadSessionKey).(*uploadSession)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, s.toGraph(g.absoluteURL(r, "/uploadSessions/"+s.ID)))
+}
+
+// PutUploadSession uploads a fragment of the file, see
+// https://docs.microsoft.com/en-us/graph/api/driveitem-createuploadsession?view=graph-rest-1.0#upload-bytes-to-the-upload-session
+//
+// Fragments of the same session are uploaded one after the other, but the
+// lock only covers this instance. Across instances the TUS endpoint rejects
+// a fragment sent at a stale offset, the offset of the session is then read
+// back from it and the client is told to resume there.
+func (g Graph) PutUploadSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	s := ctx.Value(uploadSessionKey).(*uploadSession)
+
+	unlock := g.uploadLocks.lock(s.ID)
+	defer unlock()
+
+	// another fragment may have been uploaded while waiting for the lock
+	s, err := g.readUploadSession(s.ID)
+	if err != nil {
+		g.logger.Info().Err(err).Msg("Failed to read upload session")
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+		return
+	}
+
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil || total != s.Size || r.ContentLength != end-start+1 {
+		g.logger.Debug().Str("range", r.Header.Get("Content-Range")).Msg("invalid content range")
+		errorcode.InvalidRange.Render(w, r, http.StatusBadRequest)
+		return
+	}
+	if start != s.Offset {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+		errorcode.InvalidRange.Render(w, r, http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	// the file may have been checked out since the last fragment
+	if err := checkRefLock(ctx, client, &storageprovider.Reference{
+		Spec: &storageprovider.Reference_Path{Path: s.Path},
+	}); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking checkout of %s", s.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	offset, err := g.patchUpload(ctx, s, r.Body, r.ContentLength)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error uploading fragment of %s", s.Path)
+		// the data gateway may have received a part of the fragment
+		if offset, herr := g.headUpload(ctx, s); herr != nil {
+			g.logger.Error().Err(herr).Msgf("error reading offset of %s", s.Path)
+		} else if offset != s.Offset {
+			s.Offset = offset
+			if err := g.writeUploadSession(s); err != nil {
+				g.logger.Error().Err(err).Msg("error storing upload session")
+			}
+		}
+		if err == errInvalidRange {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", total))
+			errorcode.InvalidRange.Render(w, r, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	s.Offset = offset
+
+	if s.Offset < s.Size {
+		if err := g.writeUploadSession(s); err != nil {
+			g.logger.Error().Err(err).Msg("error storing upload session")
+			errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, s.toGraph(g.absoluteURL(r, "/uploadSessions/"+s.ID)))
+		return
+	}
+
+	if err := g.store.Delete(uploadSessionStoreKey(s.ID)); err != nil {
+		g.logger.Error().Err(err).Msgf("error deleting upload session %s", s.ID)
+	}
+
+	res, err := client.Stat(ctx, &storageprovider.StatRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: s.Path},
+		},
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", s.Path)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc stat %s", s.Path)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	item, err := cs3ResourceToDriveItem(res.Info, s.root())
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error encoding response as json %s", err)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if s.Replace {
+		status = http.StatusOK
+	}
+	render.Status(r, status)
+	render.JSON(w, r, item)
+}
+
+// patchUpload forwards a fragment to the TUS endpoint of the data gateway
+// and returns the new offset of the upload. It returns errInvalidRange if
+// the upload is not at the offset of the session.
+func (g Graph) patchUpload(ctx context.Context, s *uploadSession, body io.Reader, length int64) (int64, error) {
+	req, err := newDataRequest(ctx, http.MethodPatch, s.Endpoint, s.Transfer, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+
+	res, err := g.dataClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+	case http.StatusConflict:
+		// the offset moved on, e.g. a fragment was sent to another instance
+		return 0, errInvalidRange
+	default:
+		return 0, fmt.Errorf("unexpected data gateway response %d", res.StatusCode)
+	}
+	if o := res.Header.Get("Upload-Offset"); o != "" {
+		return strconv.ParseInt(o, 10, 64)
+	}
+	return s.Offset + length, nil
+}
+
+// headUpload asks the TUS endpoint of the data gateway for the offset of
+// the upload.
+func (g Graph) headUpload(ctx context.Context, s *uploadSession) (int64, error) {
+	req, err := newDataRequest(ctx, http.MethodHead, s.Endpoint, s.Transfer, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+
+	res, err := g.dataClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected data gateway response %d", res.StatusCode)
+	}
+	return strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// DeleteUploadSession cancels an upload session, see
+// https://docs.microsoft.com/en-us/graph/api/driveitem-createuploadsession?view=graph-rest-1.0#cancel-the-upload-session
+func (g Graph) DeleteUploadSession(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(uploadSessionKey).(*uploadSession)
+
+	unlock := g.uploadLocks.lock(s.ID)
+	defer unlock()
+
+	if s.Endpoint != "" {
+		// termination is an optional TUS extension, the upload expires anyway
+		req, err := newDataRequest(r.Context(), http.MethodDelete, s.Endpoint, s.Transfer, nil)
+		if err == nil {
+			req.Header.Set("Tus-Resumable", "1.0.0")
+			var res *http.Response
+			if res, err = g.dataClient.Do(req); err == nil {
+				res.Body.Close()
+			}
+		}
+		if err != nil {
+			g.logger.Info().Err(err).Msgf("could not terminate upload of %s", s.Path)
+		}
+	}
+
+	if err := g.store.Delete(uploadSessionStoreKey(s.ID)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		g.logger.Error().Err(err).Msgf("error deleting upload session %s", s.ID)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package svc
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header            string
+		start, end, total int64
+		wantErr           bool
+	}{
+		{header: "bytes 0-25/128", start: 0, end: 25, total: 128},
+		{header: "bytes 26-127/128", start: 26, end: 127, total: 128},
+		{header: "bytes 0-0/1", start: 0, end: 0, total: 1},
+		{header: "", wantErr: true},
+		{header: "items 0-25/128", wantErr: true},
+		{header: "bytes 0-25", wantErr: true},
+		{header: "bytes 0-25/*", wantErr: true},
+		{header: "bytes */128", wantErr: true},
+		{header: "bytes 25-0/128", wantErr: true},
+		{header: "bytes 0-128/128", wantErr: true},
+		{header: "bytes -1-25/128", wantErr: true},
+		{header: "bytes 0/25-128", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, total, err := parseContentRange(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContentRange(%q) error = %v, want error %v", tt.header, err, tt.wantErr)
+			}
+			if start != tt.start || end != tt.end || total != tt.total {
+				t.Errorf("parseContentRange(%q) = %d, %d, %d, want %d, %d, %d", tt.header, start, end, total, tt.start, tt.end, tt.total)
+			}
+		})
+	}
+}
+
+func TestUploadSessionExpireWith(t *testing.T) {
+	jwt := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{"earlier token", jwt(`{"exp":` + formatUnix(now) + `}`), now},
+		{"later token", jwt(`{"exp":` + formatUnix(later.Add(time.Hour)) + `}`), later},
+		{"no expiration", jwt(`{"aud":"reva"}`), later},
+		{"not a jwt", "token", later},
+		{"invalid claims", "e30.!!!.sig", later},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &uploadSession{Expiration: later}
+			s.expireWith(tt.token)
+			if !s.Expiration.Equal(tt.want) {
+				t.Errorf("expireWith() expiration = %v, want %v", s.Expiration, tt.want)
+			}
+		})
+	}
+}
+
+func formatUnix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
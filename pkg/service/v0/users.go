@@ -67,6 +67,7 @@ func (g Graph) GetUsers(w http.ResponseWriter, r *http.Request) {
 		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
 		return
 	}
+	defer con.Close()
 
 	result, err := g.ldapSearch(con, "(objectclass=*)", g.config.Ldap.BaseDNUsers)
 
@@ -0,0 +1,222 @@
+package svc
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"time"
+
+	gateway "github.com/cs3org/go-cs3apis/cs3/gateway/v1beta1"
+	cs3rpc "github.com/cs3org/go-cs3apis/cs3/rpc/v1beta1"
+	storageprovider "github.com/cs3org/go-cs3apis/cs3/storage/provider/v1beta1"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/owncloud/ocis-graph/pkg/service/v0/errorcode"
+	msgraph "github.com/yaegashi/msgraph.go/v1.0"
+)
+
+// itemVersion is a version of a file.
+type itemVersion struct {
+	info    *storageprovider.ResourceInfo
+	version *storageprovider.FileVersion
+}
+
+// cs3VersionToGraph converts a CS3 file version into a drive item version.
+// The id is the encoded version key. CS3 versions do not carry the user
+// that modified them, so lastModifiedBy is not set.
+func cs3VersionToGraph(v *storageprovider.FileVersion) *msgraph.DriveItemVersion {
+	id := base64.URLEncoding.EncodeToString([]byte(v.Key))
+	size := new(int)
+	*size = int(v.Size) // uint64 -> int :boom:
+	lastModified := time.Unix(int64(v.Mtime), 0)
+
+	return &msgraph.DriveItemVersion{
+		BaseItemVersion: msgraph.BaseItemVersion{
+			Entity: msgraph.Entity{
+				ID: &id,
+			},
+			LastModifiedDateTime: &lastModified,
+		},
+		Size: size,
+	}
+}
+
+// listVersions returns the versions of a file, the newest first.
+func listVersions(ctx context.Context, client gateway.GatewayAPIClient, info *storageprovider.ResourceInfo) ([]*storageprovider.FileVersion, error) {
+	res, err := client.ListFileVersions(ctx, &storageprovider.ListFileVersionsRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: info.Path},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		return nil, &statusError{status: res.Status}
+	}
+	versions := res.Versions
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Mtime > versions[j].Mtime
+	})
+	return versions, nil
+}
+
+// statFile stats the item resolved by DriveItemCtx and makes sure it is a
+// file. It renders the error response if not.
+func (g Graph) statFile(w http.ResponseWriter, r *http.Request, client gateway.GatewayAPIClient) (*storageprovider.ResourceInfo, bool) {
+	ref := r.Context().Value(driveItemKey).(*storageprovider.Reference)
+
+	statRes, err := client.Stat(r.Context(), &storageprovider.StatRequest{
+		Ref:                   ref,
+		ArbitraryMetadataKeys: driveItemMetadataKeys,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending stat grpc request %s", ref)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return nil, false
+	}
+	if statRes.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", statRes.Status.Code.String()).Msgf("error calling grpc stat %s", ref)
+		renderStatus(w, r, statRes.Status)
+		return nil, false
+	}
+	if statRes.Info.Type != storageprovider.ResourceType_RESOURCE_TYPE_FILE {
+		errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+		return nil, false
+	}
+	return statRes.Info, true
+}
+
+// GetVersions lists the previous versions of the file resolved by DriveItemCtx,
+// see https://docs.microsoft.com/en-us/graph/api/driveitem-list-versions?view=graph-rest-1.0
+func (g Graph) GetVersions(w http.ResponseWriter, r *http.Request) {
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	info, ok := g.statFile(w, r, client)
+	if !ok {
+		return
+	}
+
+	versions, err := listVersions(r.Context(), client, info)
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error listing versions of %s", info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	items := make([]*msgraph.DriveItemVersion, 0, len(versions))
+	for _, v := range versions {
+		items = append(items, cs3VersionToGraph(v))
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, &listResponse{Value: items})
+}
+
+// VersionCtx middleware is used to load a version of the file resolved by
+// DriveItemCtx from the URL parameters passed through as the request. In
+// case the version could not be found, we stop here and return a 404.
+func (g Graph) VersionCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versionID := chi.URLParam(r, "versionID")
+		key, err := base64.URLEncoding.DecodeString(versionID)
+		if err != nil {
+			g.logger.Info().Err(err).Msgf("Invalid version id %s", versionID)
+			errorcode.InvalidRequest.Render(w, r, http.StatusBadRequest)
+			return
+		}
+
+		client, err := g.GetClient()
+		if err != nil {
+			g.logger.Err(err).Msg("error getting grpc client")
+			errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+			return
+		}
+
+		info, ok := g.statFile(w, r, client)
+		if !ok {
+			return
+		}
+
+		versions, err := listVersions(r.Context(), client, info)
+		if err != nil {
+			g.logger.Error().Err(err).Msgf("error listing versions of %s", info.Path)
+			renderError(w, r, err)
+			return
+		}
+		for _, v := range versions {
+			if v.Key == string(key) {
+				ctx := context.WithValue(r.Context(), versionKey, &itemVersion{info: info, version: v})
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		g.logger.Info().Msgf("Failed to read version %s of %s", key, info.Path)
+		errorcode.ItemNotFound.Render(w, r, http.StatusNotFound)
+	})
+}
+
+// GetVersion returns the version resolved by VersionCtx.
+func (g Graph) GetVersion(w http.ResponseWriter, r *http.Request) {
+	v := r.Context().Value(versionKey).(*itemVersion)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, cs3VersionToGraph(v.version))
+}
+
+// GetVersionContent would stream the content of the version resolved by
+// VersionCtx, see https://docs.microsoft.com/en-us/graph/api/driveitemversion-get-contents?view=graph-rest-1.0
+//
+// The CS3 api has no reference to a file version: InitiateFileDownload
+// only takes the reference of the file, and the storage drivers only expose
+// DownloadRevision to reva internally. Until the gateway can download a
+// version the content can only be reached by restoring the version first.
+func (g Graph) GetVersionContent(w http.ResponseWriter, r *http.Request) {
+	errorcode.NotSupported.Render(w, r, http.StatusNotImplemented)
+}
+
+// RestoreVersion makes the version resolved by VersionCtx the current
+// version, see https://docs.microsoft.com/en-us/graph/api/driveitemversion-restore?view=graph-rest-1.0
+func (g Graph) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	v := ctx.Value(versionKey).(*itemVersion)
+
+	if err := checkLock(ctx, v.info); err != nil {
+		g.logger.Debug().Err(err).Msgf("error checking checkout of %s", v.info.Path)
+		renderError(w, r, err)
+		return
+	}
+
+	client, err := g.GetClient()
+	if err != nil {
+		g.logger.Err(err).Msg("error getting grpc client")
+		errorcode.ServiceNotAvailable.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := client.RestoreFileVersion(ctx, &storageprovider.RestoreFileVersionRequest{
+		Ref: &storageprovider.Reference{
+			Spec: &storageprovider.Reference_Path{Path: v.info.Path},
+		},
+		Key: v.version.Key,
+	})
+	if err != nil {
+		g.logger.Error().Err(err).Msgf("error sending restore file version grpc request %s", v.info.Path)
+		errorcode.GeneralException.Render(w, r, http.StatusInternalServerError)
+		return
+	}
+	if res.Status.Code != cs3rpc.Code_CODE_OK {
+		g.logger.Debug().Str("code", res.Status.Code.String()).Msgf("error calling grpc restore file version %s", v.info.Path)
+		renderStatus(w, r, res.Status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
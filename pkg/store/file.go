@@ -0,0 +1,88 @@
+package store
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewFile returns a store that keeps every value in a file below dir, so
+// the values survive a restart of the service.
+func NewFile(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &file{
+		dir: dir,
+	}, nil
+}
+
+type file struct {
+	dir string
+}
+
+func (f *file) path(key string) string {
+	return filepath.Join(f.dir, url.PathEscape(key))
+}
+
+// Read implements the Store interface.
+func (f *file) Read(key string) ([]byte, error) {
+	value, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// Write implements the Store interface.
+func (f *file) Write(key string, value []byte) error {
+	// write to a temporary file first, so readers never see partial values
+	tmp, err := ioutil.TempFile(f.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+// Delete implements the Store interface.
+func (f *file) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List implements the Store interface.
+func (f *file) List(prefix string) ([]string, error) {
+	infos, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			continue
+		}
+		key, err := url.PathUnescape(info.Name())
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"strings"
+	"sync"
+)
+
+// NewMemory returns a store that keeps all values in memory. The values
+// are lost when the service restarts.
+func NewMemory() Store {
+	return &memory{
+		values: map[string][]byte{},
+	}
+}
+
+type memory struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+// Read implements the Store interface.
+func (m *memory) Read(key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Write implements the Store interface.
+func (m *memory) Write(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete implements the Store interface.
+func (m *memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values, key)
+	return nil
+}
+
+// List implements the Store interface.
+func (m *memory) List(prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []string{}
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
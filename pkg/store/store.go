@@ -0,0 +1,35 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/owncloud/ocis-graph/pkg/config"
+)
+
+// ErrNotFound is returned if a key does not exist in the store.
+var ErrNotFound = errors.New("key not found")
+
+// Store persists state of the graph service, e.g. upload sessions.
+type Store interface {
+	// Read returns the value of key or ErrNotFound.
+	Read(key string) ([]byte, error)
+	// Write creates or replaces the value of key.
+	Write(key string, value []byte) error
+	// Delete removes key, it does not fail if the key does not exist.
+	Delete(key string) error
+	// List returns all keys starting with prefix.
+	List(prefix string) ([]string, error)
+}
+
+// New initializes the store configured in cfg.
+func New(cfg config.Store) (Store, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemory(), nil
+	case "file":
+		return NewFile(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown store type %s", cfg.Type)
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/owncloud/ocis-graph/pkg/config"
+)
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocis-graph-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"memory", NewMemory()},
+		{"file", file},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.store
+
+			if _, err := s.Read("uploads/missing"); err != ErrNotFound {
+				t.Errorf("Read() of a missing key error = %v, want %v", err, ErrNotFound)
+			}
+
+			for key, value := range map[string]string{
+				"uploads/a":         "first",
+				"uploads/b":         "second",
+				"delta/a":           "third",
+				"following/x%2F/y:": "fourth",
+			} {
+				if err := s.Write(key, []byte(value)); err != nil {
+					t.Fatalf("Write(%q) error = %v", key, err)
+				}
+			}
+			if err := s.Write("uploads/a", []byte("replaced")); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			for key, want := range map[string]string{
+				"uploads/a":         "replaced",
+				"following/x%2F/y:": "fourth",
+			} {
+				got, err := s.Read(key)
+				if err != nil {
+					t.Fatalf("Read(%q) error = %v", key, err)
+				}
+				if string(got) != want {
+					t.Errorf("Read(%q) = %q, want %q", key, got, want)
+				}
+			}
+
+			keys, err := s.List("uploads/")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			sort.Strings(keys)
+			if want := []string{"uploads/a", "uploads/b"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("List() = %v, want %v", keys, want)
+			}
+
+			if err := s.Delete("uploads/a"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := s.Delete("uploads/a"); err != nil {
+				t.Errorf("Delete() of a missing key error = %v", err)
+			}
+			if _, err := s.Read("uploads/a"); err != ErrNotFound {
+				t.Errorf("Read() of a deleted key error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestMemoryCopiesValues(t *testing.T) {
+	s := NewMemory()
+	value := []byte("value")
+	if err := s.Write("key", value); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	value[0] = 'X'
+
+	got, err := s.Read("key")
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	got[1] = 'X'
+
+	if got, _ := s.Read("key"); string(got) != "value" {
+		t.Errorf("Read() = %q, want %q", got, "value")
+	}
+}
+
+func TestFilePersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocis-graph-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(config.Store{Type: "file", Path: dir})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.Write("uploads/a", []byte("value")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	reopened, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("NewFile() error = %v", err)
+	}
+	if got, err := reopened.Read("uploads/a"); err != nil || string(got) != "value" {
+		t.Errorf("Read() after reopening = %q, %v, want %q", got, err, "value")
+	}
+}
+
+func TestNewUnknownType(t *testing.T) {
+	if _, err := New(config.Store{Type: "unknown"}); err == nil {
+		t.Error("New() of an unknown store type succeeded")
+	}
+}
@@ -0,0 +1,153 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+
+	// register the decoders of the supported formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// MaxFileSize is the size of the largest image a thumbnail is generated for.
+	MaxFileSize = 20 * 1024 * 1024
+	// MaxPixels is the number of pixels of the largest image a thumbnail is
+	// generated for. Decoded images take up to 8 bytes per pixel.
+	MaxPixels = 16 * 1000 * 1000
+)
+
+// ErrTooLarge is returned for images exceeding MaxFileSize or MaxPixels.
+var ErrTooLarge = errors.New("image too large")
+
+// mimeTypes lists the supported image formats.
+var mimeTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// Supported checks if thumbnails can be generated for a mime type.
+func Supported(mimeType string) bool {
+	return mimeTypes[mimeType]
+}
+
+// Generate decodes the image read from r and scales it down to fit into a
+// square of size pixels. Images are never scaled up. The thumbnail is
+// encoded as JPEG, transparent areas become white.
+func Generate(r io.Reader, size int) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxFileSize {
+		return nil, ErrTooLarge
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, scale(img, size), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pixelFunc returns the alpha premultiplied color of a pixel like
+// image.Image.At, but reads the common image types directly instead of
+// allocating a color for every pixel.
+func pixelFunc(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch img := src.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101, uint32(p[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			a = uint32(p[3]) * 0x101
+			return uint32(p[0]) * a / 0xff, uint32(p[1]) * a / 0xff, uint32(p[2]) * a / 0xff, a
+		}
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			r8, g8, b8 := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			return uint32(r8) * 0x101, uint32(g8) * 0x101, uint32(b8) * 0x101, 0xffff
+		}
+	default:
+		return func(x, y int) (r, g, b, a uint32) {
+			return src.At(x, y).RGBA()
+		}
+	}
+}
+
+// scale shrinks src with a box filter so it fits into a square of size pixels.
+func scale(src image.Image, size int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, sh*size/sw
+		} else {
+			dw, dh = sw*size/sh, size
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	pixel := pixelFunc(src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := sb.Min.Y+y*sh/dh, sb.Min.Y+(y+1)*sh/dh
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := sb.Min.X+x*sw/dw, sb.Min.X+(x+1)*sw/dw
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := pixel(sx, sy)
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// the colors are alpha premultiplied, so adding the missing
+			// alpha composes the pixel over a white background
+			white := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
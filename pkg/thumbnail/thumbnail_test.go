@@ -0,0 +1,96 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// fill returns an image of the given type filled with c.
+func fill(kind string, w, h int, c color.Color) image.Image {
+	r := image.Rect(0, 0, w, h)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch kind {
+	case "rgba":
+		img = image.NewRGBA(r)
+	case "nrgba":
+		img = image.NewNRGBA(r)
+	case "gray":
+		img = image.NewGray(r)
+	case "ycbcr":
+		ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+		cr, cg, cb, _ := c.RGBA()
+		y, u, v := color.RGBToYCbCr(uint8(cr>>8), uint8(cg>>8), uint8(cb>>8))
+		for i := range ycbcr.Y {
+			ycbcr.Y[i] = y
+		}
+		for i := range ycbcr.Cb {
+			ycbcr.Cb[i], ycbcr.Cr[i] = u, v
+		}
+		return ycbcr
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// near checks that two color channels differ by at most one step.
+func near(a, b uint8) bool {
+	d := int(a) - int(b)
+	return d >= -1 && d <= 1
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		w, h   int
+		size   int
+		color  color.Color
+		dw, dh int
+		want   color.RGBA
+	}{
+		{"landscape", "rgba", 400, 200, 100, color.RGBA{R: 0xff, A: 0xff}, 100, 50, color.RGBA{R: 0xff, A: 0xff}},
+		{"portrait", "rgba", 200, 400, 100, color.RGBA{G: 0xff, A: 0xff}, 50, 100, color.RGBA{G: 0xff, A: 0xff}},
+		{"not scaled up", "rgba", 20, 10, 100, color.RGBA{B: 0xff, A: 0xff}, 20, 10, color.RGBA{B: 0xff, A: 0xff}},
+		{"thin", "rgba", 1000, 1, 100, color.RGBA{A: 0xff}, 100, 1, color.RGBA{A: 0xff}},
+		{"transparent over white", "nrgba", 100, 100, 10, color.NRGBA{}, 10, 10, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{"half transparent", "nrgba", 100, 100, 10, color.NRGBA{A: 0x80}, 10, 10, color.RGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}},
+		{"ycbcr", "ycbcr", 64, 32, 16, color.RGBA{R: 0x80, G: 0x40, B: 0x20, A: 0xff}, 16, 8, color.RGBA{R: 0x80, G: 0x40, B: 0x20, A: 0xff}},
+		{"generic", "gray", 64, 64, 16, color.Gray{Y: 0x60}, 16, 16, color.RGBA{R: 0x60, G: 0x60, B: 0x60, A: 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := scale(fill(tt.kind, tt.w, tt.h, tt.color), tt.size)
+
+			if b := dst.Bounds(); b.Dx() != tt.dw || b.Dy() != tt.dh {
+				t.Fatalf("scale() size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.dw, tt.dh)
+			}
+			for _, p := range []image.Point{{0, 0}, {tt.dw - 1, tt.dh - 1}, {tt.dw / 2, tt.dh / 2}} {
+				got := dst.RGBAAt(p.X, p.Y)
+				if !near(got.R, tt.want.R) || !near(got.G, tt.want.G) || !near(got.B, tt.want.B) || got.A != tt.want.A {
+					t.Errorf("scale() pixel %v = %v, want %v", p, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScaleAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{A: 0xff})
+	src.Set(1, 0, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	got := scale(src, 1).RGBAAt(0, 0)
+	want := color.RGBA{R: 0x7f, G: 0x7f, B: 0x7f, A: 0xff}
+	if !near(got.R, want.R) || !near(got.G, want.G) || !near(got.B, want.B) {
+		t.Errorf("scale() = %v, want %v", got, want)
+	}
+}